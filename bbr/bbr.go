@@ -3,17 +3,25 @@ package bbr
 import (
 	"context"
 	"errors"
+	"conan/log"
 	"conan/rolling"
 	cpustate "conan/sys/cpu"
 	"math"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	cpuInterval = time.Millisecond * 250 // 采集cpu使用率的间隔
+)
+
 var (
-	cpu         int64
-	decay       = 0.95 // 这里这个值是看自己项目进行调整的
-	initTime    = time.Now()
+	ErrLimitExceed = errors.New("bbr limit exceed")
+
+	initOnce    sync.Once
+	cpu         int64  // sys/cpu 采集到的cpu使用率 由各个限流器自己做平滑
+	decay       = 0.95 // 默认的衰减系数 可以通过 WithDecay 调整
 	defaultConf = &Config{
 		Window:       time.Second * 10,
 		WinBucket:    100,
@@ -21,21 +29,26 @@ var (
 	}
 )
 
+// Init 开始定时采集cpu使用率 多次调用只会启动一次
 func Init() {
-	go cpuProc()
+	initOnce.Do(func() {
+		// 非linux时读取不到cpu使用率 这时只能通过 WithCPU 自定义压力信号
+		if err := cpustate.Init(); err != nil {
+			log.Warn("BBR Init CPU Fail cpu usage is always 0 , err is %s", err.Error())
+		}
+		go cpuProc()
+	})
 }
 
 type cpuGetter func() int64
 
 func cpuProc() {
-	ticker := time.Ticker{}
+	ticker := time.NewTicker(cpuInterval)
+	defer ticker.Stop()
 	for range ticker.C {
 		s := cpustate.State{}
 		cpustate.ReadState(&s)
-		prevCpu := atomic.LoadInt64(&cpu)
-		// EWMA
-		curCpu := int64(float64(prevCpu)*decay + (1.0-decay)*float64(s.Usage))
-		atomic.StoreInt64(&cpu, curCpu)
+		atomic.StoreInt64(&cpu, int64(s.Usage))
 	}
 }
 
@@ -47,11 +60,12 @@ type Config struct {
 }
 
 type Stat struct {
-	CPU       int64
-	InFlight  int64
-	MaxFlight int64
-	MinRt     int64
-	MaxPass   int64
+	CPU        int64
+	InFlight   int64
+	MaxFlight  int64
+	MinRt      int64
+	MaxPass    int64
+	Priorities []PriorityStat // 按照优先级从低到高
 }
 
 type BBR struct {
@@ -61,10 +75,18 @@ type BBR struct {
 	inflight        int64                  // 当前正在请求的数量
 	winBucketPreSec int64
 	config          *Config
+	now             func() time.Time
+	start           time.Time    // 创建的时间 prevDrop 以及 rt 都是相对于该时间计算的
 	prevDrop        atomic.Value // 上次丢弃的时间 。这里的时间指 time.Since(上次计算时间) 这里保存的是一个time.Duration
 	// prePropHit      int32 // 不知道有什么用 感觉可以不要
 	rawMaxPASS int64 // 最大通过的请求数量
 	rawMinRt   int64 // 最小的请求处理时间
+
+	classes [len(priorities)]classStat // 每个优先级的统计
+}
+
+func (b *BBR) since() time.Duration {
+	return b.now().Sub(b.start)
 }
 
 func (b *BBR) maxPass() int64 {
@@ -122,6 +144,10 @@ func (b *BBR) minRt() int64 {
 			result = math.Min(avg, result)
 
 		}
+		// 窗口内没有数据
+		if result == math.MaxFloat64 {
+			return 0
+		}
 		return result
 	}))
 
@@ -139,7 +165,12 @@ func (b *BBR) maxFlight() int64 {
 	return int64(math.Floor(float64(b.maxPass()*b.minRt()*b.winBucketPreSec)/1000.0 + 0.5))
 }
 
-func (b *BBR) shouldDrop() bool {
+// 当前优先级的请求 inflight 的上限
+func (b *BBR) overLimit(inFlight int64, p Priority) bool {
+	return float64(inFlight) > float64(b.maxFlight())*priorityRatio[p.index()]
+}
+
+func (b *BBR) shouldDrop(p Priority) bool {
 	// 这里表示 cpu使用率 小于 当前设置的 阈值
 	if b.cpu() < b.config.CPUThreshold {
 		prevDrop, _ := b.prevDrop.Load().(time.Duration)
@@ -150,10 +181,10 @@ func (b *BBR) shouldDrop() bool {
 
 		// 这里表示 两次 限流的时间差 不到1s
 		// 这里就要防止 瞬时流量的 激增了
-		if time.Since(initTime)-prevDrop <= time.Second {
+		if b.since()-prevDrop <= time.Second {
 			// 这里去估算 是否要丢弃
 			inFlight := atomic.LoadInt64(&b.inflight)
-			return inFlight > 1 && inFlight > b.maxFlight()
+			return inFlight > 1 && b.overLimit(inFlight, p)
 		}
 		// 这里表示不是激增的情况
 		b.prevDrop.Store(time.Duration(0))
@@ -161,32 +192,40 @@ func (b *BBR) shouldDrop() bool {
 	}
 	// 这里就表示了 当前的cpu 使用率超过了阈值 可能超负载了 可能需要Drop了
 	inFlight := atomic.LoadInt64(&b.inflight)
-	drop := inFlight > b.maxFlight()
+	drop := b.overLimit(inFlight, p)
 	if drop {
-		b.prevDrop.Store(time.Since(initTime))
+		b.prevDrop.Store(b.since())
 	}
 	//if drop {
 	//	prevDrop, _ := b.prevDrop.Load().(time.Duration)
 	//	if prevDrop != 0 {
 	//		return drop
 	//	}
-	//  b.prevDrop.Store(time.Since(initTime))
+	//  b.prevDrop.Store(b.since())
 	//}
 	return drop
 }
 
+// Allow 判断请求是否可以通过 优先级通过 WithPriority 设置在 ctx 中
+// 返回nil时 请求处理完成后必须调用返回的函数
 func (b *BBR) Allow(ctx context.Context) (func(info DoneInfo), error) {
-	if b.shouldDrop() {
-		return nil, errors.New("should drop")
+	p, _ := PriorityFromContext(ctx)
+	class := &b.classes[p.index()]
+	if b.shouldDrop(p) {
+		atomic.AddInt64(&class.drop, 1)
+		return nil, ErrLimitExceed
 	}
 	atomic.AddInt64(&b.inflight, 1)
-	startTime := time.Since(initTime)
+	atomic.AddInt64(&class.inflight, 1)
+	atomic.AddInt64(&class.pass, 1)
+	startTime := b.since()
 
 	return func(info DoneInfo) {
 		// 计算本次的rt
-		rt := float64((time.Since(initTime) - startTime) / time.Millisecond)
+		rt := float64((b.since() - startTime) / time.Millisecond)
 		b.rtStat.Add(rt)
 		atomic.AddInt64(&b.inflight, -1)
+		atomic.AddInt64(&class.inflight, -1)
 		switch info.Op {
 		case Success:
 			b.passStat.Add(1)
@@ -196,41 +235,75 @@ func (b *BBR) Allow(ctx context.Context) (func(info DoneInfo), error) {
 	}, nil
 }
 
-func newLimiter(conf *Config) Limiter {
+// NewLimiter 创建一个bbr限流器 conf 为空时使用默认配置
+// 使用默认的cpu信号时 需要调用 Init 之后才会开始采集
+func NewLimiter(conf *Config, opts ...Option) *BBR {
 	if conf == nil {
 		conf = defaultConf
 	}
 
+	o := &options{
+		cpu: func() int64 {
+			return atomic.LoadInt64(&cpu)
+		},
+		decay: decay,
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	bucketDuration := conf.Window / time.Duration(conf.WinBucket)
 
 	passStat := rolling.NewRollingCounter(rolling.RollingCounterOpts{
 		Size:           conf.WinBucket,
 		BucketDuration: bucketDuration,
+		Now:            o.now,
 	})
 	rtStat := rolling.NewRollingCounter(rolling.RollingCounterOpts{
 		Size:           conf.WinBucket,
 		BucketDuration: bucketDuration,
+		Now:            o.now,
 	})
-	cpuFunc := func() int64 {
-		return atomic.LoadInt64(&cpu)
+	cpuEWMA := &ewma{
+		get:   o.cpu,
+		decay: o.decay,
+		now:   o.now,
 	}
 
 	limiter := &BBR{
-		cpu:             cpuFunc,
+		cpu:             cpuEWMA.load,
 		passStat:        passStat,
 		rtStat:          rtStat,
 		winBucketPreSec: int64(time.Second) / int64(bucketDuration),
 		config:          conf,
+		now:             o.now,
+		start:           o.now(),
 	}
 	return limiter
 }
 
 func (b *BBR) Stat() Stat {
 	return Stat{
-		CPU:       b.cpu(),
-		InFlight:  atomic.LoadInt64(&b.inflight),
-		MaxFlight: 0,
-		MinRt:     0,
-		MaxPass:   0,
+		CPU:        b.cpu(),
+		InFlight:   atomic.LoadInt64(&b.inflight),
+		MaxFlight:  b.maxFlight(),
+		MinRt:      b.minRt(),
+		MaxPass:    b.maxPass(),
+		Priorities: b.priorityStat(),
+	}
+}
+
+func (b *BBR) priorityStat() []PriorityStat {
+	stats := make([]PriorityStat, 0, len(priorities))
+	for _, p := range priorities {
+		class := &b.classes[p.index()]
+		stats = append(stats, PriorityStat{
+			Priority: p,
+			InFlight: atomic.LoadInt64(&class.inflight),
+			Pass:     atomic.LoadInt64(&class.pass),
+			Drop:     atomic.LoadInt64(&class.drop),
+		})
 	}
+	return stats
 }
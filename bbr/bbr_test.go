@@ -0,0 +1,204 @@
+package bbr
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestStat(t *testing.T) {
+	b := NewLimiter(&Config{
+		Window:       time.Second,
+		WinBucket:    10,
+		CPUThreshold: 800,
+	})
+
+	for i := 0; i < 30; i++ {
+		for j := 0; j < 5; j++ {
+			done, err := b.Allow(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(2 * time.Millisecond)
+			done(DoneInfo{Op: Success})
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stat := b.Stat()
+	// 这里的耗时受机器负载影响 maxFlight 的计算由 TestShouldDrop 验证
+	if stat.InFlight != 0 || stat.MaxPass < 2 || stat.MinRt < 2 {
+		t.Fatalf("stat is %+v", stat)
+	}
+}
+
+// 每个bucket(100ms) 通过10个请求 每个请求耗时50ms
+// maxFlight = maxPass(10) * minRt(50ms) * 10 / 1000 = 5
+func warmUp(t *testing.T, b *BBR, clock *fakeClock) {
+	for i := 0; i < 10; i++ {
+		dones := make([]func(DoneInfo), 0, 10)
+		for j := 0; j < 10; j++ {
+			done, err := b.Allow(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			dones = append(dones, done)
+		}
+		clock.Add(50 * time.Millisecond)
+		for _, done := range dones {
+			done(DoneInfo{Op: Success})
+		}
+		clock.Add(50 * time.Millisecond)
+	}
+
+	if stat := b.Stat(); stat.MaxPass != 10 || stat.MinRt != 50 || stat.MaxFlight != 5 {
+		t.Fatalf("stat is %+v", stat)
+	}
+}
+
+func TestShouldDrop(t *testing.T) {
+	var load int64 = 100
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := NewLimiter(&Config{
+		Window:       2 * time.Second,
+		WinBucket:    20,
+		CPUThreshold: 800,
+	}, WithCPU(func() int64 {
+		return atomic.LoadInt64(&load)
+	}), WithDecay(0), WithClock(clock.Now))
+
+	warmUp(t, b, clock)
+
+	atomic.StoreInt64(&load, 900)
+	clock.Add(250 * time.Millisecond)
+
+	dones := make([]func(DoneInfo), 0, 10)
+	for i := 0; i < 10; i++ {
+		if done, err := b.Allow(context.Background()); err == nil {
+			dones = append(dones, done)
+		}
+	}
+	// inflight 超过 maxFlight 之后开始丢弃
+	if len(dones) != 6 {
+		t.Fatalf("pass is %d when cpu is overload", len(dones))
+	}
+
+	// cpu降下来之后 1s内仍然会根据 inflight 判断
+	atomic.StoreInt64(&load, 100)
+	clock.Add(250 * time.Millisecond)
+	if _, err := b.Allow(context.Background()); err == nil {
+		t.Fatal("request should be dropped in 1s after last drop")
+	}
+	for _, done := range dones {
+		done(DoneInfo{Op: Success})
+	}
+	if _, err := b.Allow(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	var load int64 = 100
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := NewLimiter(&Config{
+		Window:       2 * time.Second,
+		WinBucket:    20,
+		CPUThreshold: 800,
+	}, WithCPU(func() int64 {
+		return atomic.LoadInt64(&load)
+	}), WithDecay(0), WithClock(clock.Now))
+	warmUp(t, b, clock)
+
+	atomic.StoreInt64(&load, 900)
+	clock.Add(250 * time.Millisecond)
+
+	allow := func(p Priority) int {
+		ctx := WithPriority(context.Background(), p)
+		n := 0
+		for i := 0; i < 10; i++ {
+			if _, err := b.Allow(ctx); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+	// maxFlight 为5 low 最多使用一半 normal 使用全部 high 可以使用预留的余量
+	if n := allow(PriorityLow); n != 3 {
+		t.Fatalf("low pass is %d", n)
+	}
+	if n := allow(PriorityNormal); n != 3 {
+		t.Fatalf("normal pass is %d", n)
+	}
+	if n := allow(PriorityHigh); n != 1 {
+		t.Fatalf("high pass is %d", n)
+	}
+
+	stats := b.Stat().Priorities
+	want := []PriorityStat{
+		{Priority: PriorityLow, InFlight: 3, Pass: 3, Drop: 7},
+		{Priority: PriorityNormal, InFlight: 3, Pass: 103, Drop: 7},
+		{Priority: PriorityHigh, InFlight: 1, Pass: 1, Drop: 9},
+	}
+	for i, s := range want {
+		if stats[i] != s {
+			t.Fatalf("priority stat is %+v , want %+v", stats[i], s)
+		}
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for s, p := range map[string]Priority{
+		"low":     PriorityLow,
+		" High ":  PriorityHigh,
+		"normal":  PriorityNormal,
+		"":        PriorityNormal,
+		"unknown": PriorityNormal,
+	} {
+		if got := ParsePriority(s); got != p {
+			t.Fatalf("%q is %s , want %s", s, got, p)
+		}
+	}
+	if p, ok := PriorityFromContext(context.Background()); ok || p != PriorityNormal {
+		t.Fatalf("priority is %s", p)
+	}
+}
+
+func TestDecay(t *testing.T) {
+	var load int64 = 1000
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := NewLimiter(nil, WithCPU(func() int64 {
+		return atomic.LoadInt64(&load)
+	}), WithDecay(0.5), WithClock(clock.Now))
+
+	if cpu := b.Stat().CPU; cpu != 1000 {
+		t.Fatalf("cpu is %d", cpu)
+	}
+	atomic.StoreInt64(&load, 0)
+	// 采样间隔内 不会重新采样
+	if cpu := b.Stat().CPU; cpu != 1000 {
+		t.Fatalf("cpu is %d", cpu)
+	}
+	clock.Add(cpuInterval)
+	if cpu := b.Stat().CPU; cpu != 500 {
+		t.Fatalf("cpu is %d", cpu)
+	}
+}
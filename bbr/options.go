@@ -0,0 +1,67 @@
+package bbr
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type options struct {
+	cpu   cpuGetter
+	decay float64
+	now   func() time.Time
+}
+
+type Option func(*options)
+
+// WithCPU 自定义压力信号 默认为 sys/cpu 采集的cpu使用率
+// 返回值会和 Config.CPUThreshold 比较 eg: 协程数 GC停顿时间 队列长度 映射到 0-1000
+func WithCPU(getter func() int64) Option {
+	return func(o *options) {
+		o.cpu = getter
+	}
+}
+
+// WithDecay 压力信号指数加权平均的衰减系数 取值为 [0, 1) 越大越平滑 为0时直接使用采样值
+func WithDecay(decay float64) Option {
+	return func(o *options) {
+		if decay >= 0 && decay < 1 {
+			o.decay = decay
+		}
+	}
+}
+
+// WithClock 自定义时钟 滑动窗口以及限流的时间间隔都使用该时钟 用于测试
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// ewma 每隔 cpuInterval 对压力信号采样一次 并做指数加权平均 间隔内读取的都是上次的结果
+type ewma struct {
+	get   cpuGetter
+	decay float64
+	now   func() time.Time
+	last  int64 // 上次采样的时间 UnixNano
+	value int64
+}
+
+func (e *ewma) load() int64 {
+	now := e.now().UnixNano()
+	last := atomic.LoadInt64(&e.last)
+	if last != 0 && now-last < int64(cpuInterval) {
+		return atomic.LoadInt64(&e.value)
+	}
+	// 只有一个协程进行采样
+	if !atomic.CompareAndSwapInt64(&e.last, last, now) {
+		return atomic.LoadInt64(&e.value)
+	}
+
+	cur := e.get()
+	if last != 0 {
+		prev := atomic.LoadInt64(&e.value)
+		cur = int64(float64(prev)*e.decay + (1.0-e.decay)*float64(cur))
+	}
+	atomic.StoreInt64(&e.value, cur)
+	return cur
+}
@@ -0,0 +1,87 @@
+package bbr
+
+import (
+	"context"
+	"strings"
+)
+
+// Priority 请求的优先级 过载时优先丢弃低优先级的请求
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1 // 批处理 爬虫等 过载时最先被丢弃
+	PriorityNormal Priority = 0  // 默认的优先级
+	PriorityHigh   Priority = 1  // 健康检查 登录 支付等 过载时尽量保证
+)
+
+var (
+	// 过载时 各个优先级可以使用的 maxFlight 的比例
+	// 低优先级的请求 inflight 较低时就开始丢弃 高优先级的请求可以使用预留出来的余量
+	priorityRatio = [...]float64{
+		PriorityLow + 1:    0.5,
+		PriorityNormal + 1: 1,
+		PriorityHigh + 1:   1.25,
+	}
+	priorities = [...]Priority{PriorityLow, PriorityNormal, PriorityHigh}
+)
+
+type priorityKey struct{}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// 在 priorityRatio 以及 BBR.classes 中的下标 无法识别的优先级作为 PriorityNormal
+func (p Priority) index() int {
+	if p < PriorityLow || p > PriorityHigh {
+		p = PriorityNormal
+	}
+	return int(p - PriorityLow)
+}
+
+// ParsePriority 解析 low normal high 无法识别时为 PriorityNormal
+func ParsePriority(s string) Priority {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow
+	case "high":
+		return PriorityHigh
+	default:
+		return PriorityNormal
+	}
+}
+
+// WithPriority 设置请求的优先级 Allow 时从 ctx 中读取
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext 返回 ctx 中的优先级 没有设置时返回 PriorityNormal false
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	p, ok := ctx.Value(priorityKey{}).(Priority)
+	if !ok {
+		return PriorityNormal, false
+	}
+	return p, true
+}
+
+// 每个优先级的统计
+type classStat struct {
+	inflight int64
+	pass     int64 // 一共通过的请求数
+	drop     int64 // 一共丢弃的请求数
+}
+
+type PriorityStat struct {
+	Priority Priority
+	InFlight int64
+	Pass     int64
+	Drop     int64
+}
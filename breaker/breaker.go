@@ -1,20 +1,50 @@
 package breaker
 
-import "time"
+import (
+	"conan/rolling"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	TypeSRE     = "sre"     // google sre 中的自适应熔断 按照概率丢弃请求
+	TypeHystrix = "hystrix" // 错误率或者连续失败达到阈值时 丢弃所有请求 适用于彻底不可用的依赖
+)
+
+var (
+	// ErrServiceUnavailable 熔断时 Allow 返回的错误
+	ErrServiceUnavailable = errors.New("breaker: service unavailable")
+
+	defaultConf = &Config{}
+)
 
 type Breaker interface {
 	Allow() error
-	MakeSuccess() // 若 Allow() == nil 则 外界需要调用该函数
-	MakeFailed()  // 若 Allow() != nil 则 外界需要调用该函数
+	MakeSuccess() // 若 Allow() == nil 并且请求成功 则 外界需要调用该函数
+	MakeFailed()  // 若 Allow() == nil 并且请求失败 则 外界需要调用该函数 Allow() != nil 时不需要调用
+	Release()     // 若 Allow() == nil 但是无法判断结果(例如调用方主动取消) 则 外界需要调用该函数 不计入成功或者失败
+	Stat() Stat
 }
 
 type Config struct {
-	SwitchOff bool    // 开关 true 为开启 默认为 false
+	SwitchOff bool    // 为 true 时关闭熔断 所有的请求都会通过 默认为 false
+	Type      string  // 熔断器的类型 TypeSRE TypeHystrix 默认为 TypeSRE
 	K         float64 // SRE 中的 盐值  可以根据实际情况 进行调整
 
 	Window  time.Duration
 	Bucket  int
-	Request int64 // 触发SRE的最小 请求数
+	Request int64 // 触发熔断的最小 请求数
+
+	// 以下为 TypeHystrix 的配置
+	ErrorPercent        int64         // 窗口内的请求数超过 Request 并且错误率(百分比)达到该值时开启 默认50
+	ConsecutiveFailures int64         // 连续失败的次数达到该值时开启 默认5
+	SleepWindow         time.Duration // 开启之后 经过多久进入半开启 默认5s
+	HalfOpenRequests    int64         // 半开启时 放行的探测请求数 全部成功时关闭 有一个失败时重新开启 默认1
+
+	// 状态变化 以及丢弃请求时的回调 为空时不回调
+	Observer Observer
 }
 
 func (c *Config) fix() {
@@ -33,14 +63,160 @@ func (c *Config) fix() {
 	if c.Window == 0 {
 		c.Window = 3 * time.Second
 	}
+
+	if c.ErrorPercent == 0 {
+		c.ErrorPercent = 50
+	}
+
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = 5
+	}
+
+	if c.SleepWindow == 0 {
+		c.SleepWindow = 5 * time.Second
+	}
+
+	if c.HalfOpenRequests == 0 {
+		c.HalfOpenRequests = 1
+	}
 }
 
 const (
-	StateOpen     = iota // 表示开启
+	StateOpen     = iota // 表示开启 sre 按照概率丢弃请求 hystrix 丢弃所有请求
 	StateClosed          // 表示关闭
-	StateHalfOpen        // 表示 半开启
+	StateHalfOpen        // 表示 半开启 只放行少量请求 成功时关闭 失败时重新开启
 )
 
-func newBreaker(c *Config) Breaker {
-	return newSre(c)
+// 成功上报1 失败上报0 Count 为总的请求数
+func newStat(conf *Config, now func() time.Time) rolling.RollingCounter {
+	return rolling.NewRollingCounter(rolling.RollingCounterOpts{
+		Size:           conf.Bucket,
+		BucketDuration: time.Duration(int64(conf.Window) / int64(conf.Bucket)),
+		Now:            now,
+	})
+}
+
+func summary(stat rolling.RollingCounter) (success int64, total int64) {
+	stat.Reduce(func(iterator rolling.Iterator) float64 {
+		for iterator.Next() {
+			b := iterator.Bucket()
+			total += b.Count
+
+			for _, p := range b.Point {
+				success += int64(p)
+			}
+		}
+		return 0
+	})
+	return
+}
+
+// NewBreaker 创建一个熔断器 c 为空时使用默认配置
+func NewBreaker(c *Config) Breaker {
+	return newBreaker("", c)
+}
+
+func newBreaker(name string, c *Config) Breaker {
+	if c == nil {
+		c = defaultConf
+	}
+	if c.SwitchOff {
+		return noopBreaker{name: name}
+	}
+	conf := *c
+	conf.fix()
+	if conf.Type == TypeHystrix {
+		return newHystrix(name, &conf, time.Now)
+	}
+	return newSre(name, &conf)
+}
+
+// 关闭熔断时使用
+type noopBreaker struct {
+	name string
+}
+
+func (noopBreaker) Allow() error { return nil }
+func (noopBreaker) MakeSuccess() {}
+func (noopBreaker) MakeFailed()  {}
+func (noopBreaker) Release()     {}
+func (b noopBreaker) Stat() Stat {
+	return Stat{Name: b.name, Type: "noop", State: StateName(StateClosed)}
+}
+
+// Group 按照名字(例如下游的host) 创建熔断器 每个名字的熔断器互不影响
+type Group struct {
+	conf *Config
+
+	mu       sync.RWMutex
+	breakers map[string]Breaker
+}
+
+// NewGroup 所有的熔断器使用同一个配置 conf 为空时使用默认配置
+func NewGroup(conf *Config) *Group {
+	if conf == nil {
+		conf = defaultConf
+	}
+	return &Group{
+		conf:     conf,
+		breakers: make(map[string]Breaker),
+	}
+}
+
+// Get 返回 name 对应的熔断器 不存在时创建
+func (g *Group) Get(name string) Breaker {
+	g.mu.RLock()
+	b, ok := g.breakers[name]
+	g.mu.RUnlock()
+	if ok {
+		return b
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if b, ok = g.breakers[name]; !ok {
+		b = newBreaker(name, g.conf)
+		g.breakers[name] = b
+	}
+	return b
+}
+
+// Stats 返回所有熔断器的统计 按照名字排序
+func (g *Group) Stats() []Stat {
+	g.mu.RLock()
+	stats := make([]Stat, 0, len(g.breakers))
+	for _, b := range g.breakers {
+		stats = append(stats, b.Stat())
+	}
+	g.mu.RUnlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
+
+// Do 熔断时直接返回 ErrServiceUnavailable 否则执行 fn
+// fn 返回nil时调用 MakeSuccess 返回错误或者 panic 时调用 MakeFailed
+func (g *Group) Do(name string, fn func() error) (err error) {
+	b := g.Get(name)
+	if err = b.Allow(); err != nil {
+		return err
+	}
+
+	finished := false
+	defer func() {
+		if !finished {
+			b.MakeFailed()
+		}
+	}()
+
+	err = fn()
+	finished = true
+	if err != nil {
+		b.MakeFailed()
+	} else {
+		b.MakeSuccess()
+	}
+	return err
 }
@@ -0,0 +1,215 @@
+package breaker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroup(t *testing.T) {
+	g := NewGroup(nil)
+	if g.Get("a") != g.Get("a") {
+		t.Fatal("breaker of the same name should be the same")
+	}
+	if g.Get("a") == g.Get("b") {
+		t.Fatal("breaker of different names should not be the same")
+	}
+	if stats := g.Stats(); len(stats) != 2 || stats[0].Name != "a" || stats[1].State != "closed" {
+		t.Fatalf("stats is %+v", stats)
+	}
+
+	// 关闭熔断时 所有的请求都会通过
+	g = NewGroup(&Config{SwitchOff: true, Request: 10})
+	failed := errors.New("failed")
+	for i := 0; i < 100; i++ {
+		if err := g.Do("a", func() error { return failed }); err != failed {
+			t.Fatalf("err is %v", err)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	g := NewGroup(&Config{Window: time.Second, Bucket: 10, Request: 100})
+	g.Do("a", func() error { return nil })
+	g.Do("a", func() error { return errors.New("failed") })
+	func() {
+		defer func() { recover() }()
+		g.Do("a", func() error { panic("boom") })
+	}()
+
+	success, total := g.Get("a").(*sre).summary()
+	if success != 1 || total != 3 {
+		t.Fatalf("success is %d , total is %d", success, total)
+	}
+}
+
+func TestHalfOpen(t *testing.T) {
+	b := NewBreaker(&Config{Window: time.Second, Bucket: 10, Request: 10}).(*sre)
+	for i := 0; i < 100; i++ {
+		b.MakeFailed()
+	}
+	dropped := 0
+	for i := 0; i < 100; i++ {
+		if b.Allow() == ErrServiceUnavailable {
+			dropped++
+		}
+	}
+	if dropped == 0 || b.State() != StateOpen {
+		t.Fatalf("dropped is %d , state is %d", dropped, b.State())
+	}
+	if stat := b.Stat(); stat.Rejections != int64(dropped) || stat.Requests != 100 || stat.Failures != 100 || stat.DropRatio <= 0 {
+		t.Fatalf("stat is %+v", stat)
+	}
+
+	// 成功率恢复之后 进入半开启 失败时重新开启
+	for i := 0; i < 1000; i++ {
+		b.MakeSuccess()
+	}
+	if err := b.Allow(); err != nil || b.State() != StateHalfOpen {
+		t.Fatalf("err is %v , state is %d", err, b.State())
+	}
+	b.MakeFailed()
+	if b.State() != StateOpen {
+		t.Fatalf("state is %d", b.State())
+	}
+
+	// 半开启时 成功之后关闭
+	b.Allow()
+	b.MakeSuccess()
+	if b.State() != StateClosed {
+		t.Fatalf("state is %d", b.State())
+	}
+}
+
+type testObserver struct {
+	changes []string
+	rejects int
+}
+
+func (o *testObserver) OnStateChange(name string, from, to int32) {
+	o.changes = append(o.changes, name+":"+StateName(to))
+}
+
+func (o *testObserver) OnReject(name string) {
+	o.rejects++
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestHystrix(t *testing.T) {
+	if _, ok := NewBreaker(&Config{Type: TypeHystrix}).(*hystrix); !ok {
+		t.Fatal("breaker should be hystrix")
+	}
+
+	observer := &testObserver{}
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	conf := &Config{
+		ConsecutiveFailures: 3,
+		SleepWindow:         time.Second,
+		HalfOpenRequests:    2,
+		Observer:            observer,
+	}
+	conf.fix()
+	h := newHystrix("mysql", conf, clock.Now)
+
+	// 连续失败3次开启 开启后丢弃所有请求
+	for i := 0; i < 3; i++ {
+		if err := h.Allow(); err != nil {
+			t.Fatal(err)
+		}
+		h.MakeFailed()
+	}
+	if err := h.Allow(); err != ErrServiceUnavailable || h.State() != StateOpen {
+		t.Fatalf("err is %v , state is %d", err, h.State())
+	}
+
+	// 经过 SleepWindow 之后半开启 只放行2个探测请求 有一个失败时重新开启
+	clock.now = clock.now.Add(time.Second)
+	if h.Allow() != nil || h.Allow() != nil || h.Allow() != ErrServiceUnavailable {
+		t.Fatal("only 2 probes should be allowed in half open")
+	}
+	h.MakeSuccess()
+	h.MakeFailed()
+	if err := h.Allow(); err != ErrServiceUnavailable || h.State() != StateOpen {
+		t.Fatalf("err is %v , state is %d", err, h.State())
+	}
+
+	// 探测请求全部成功时关闭
+	clock.now = clock.now.Add(time.Second)
+	h.Allow()
+	h.Allow()
+	h.MakeSuccess()
+	h.MakeSuccess()
+	if h.State() != StateClosed {
+		t.Fatalf("state is %d", h.State())
+	}
+
+	want := []string{"mysql:open", "mysql:half-open", "mysql:open", "mysql:half-open", "mysql:closed"}
+	if strings.Join(observer.changes, ",") != strings.Join(want, ",") {
+		t.Fatalf("changes is %v", observer.changes)
+	}
+	if observer.rejects != 3 {
+		t.Fatalf("rejects is %d", observer.rejects)
+	}
+
+	stat := h.Stat()
+	if stat != (Stat{Name: "mysql", Type: TypeHystrix, State: "closed", Requests: 10, Successes: 3, Failures: 4, Rejections: 3}) {
+		t.Fatalf("stat is %+v", stat)
+	}
+}
+
+func TestHystrixErrorPercent(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	conf := &Config{Request: 10, ErrorPercent: 50, ConsecutiveFailures: 100}
+	conf.fix()
+	h := newHystrix("", conf, clock.Now)
+
+	for i := 0; i < 9; i++ {
+		h.Allow()
+		if i%2 == 0 {
+			h.MakeSuccess()
+		} else {
+			h.MakeFailed()
+		}
+	}
+	if h.State() != StateClosed {
+		t.Fatalf("state is %d before %d requests", h.State(), conf.Request)
+	}
+	// 第10个请求失败时 错误率为50%
+	h.Allow()
+	h.MakeFailed()
+	if h.State() != StateOpen {
+		t.Fatalf("state is %d", h.State())
+	}
+}
+
+func TestHystrixRelease(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	conf := &Config{ConsecutiveFailures: 1, SleepWindow: time.Second}
+	conf.fix()
+	h := newHystrix("", conf, clock.Now)
+
+	h.Allow()
+	h.MakeFailed()
+	clock.now = clock.now.Add(time.Second)
+
+	// 探测请求被取消时 归还名额 下一个请求可以继续探测
+	if err := h.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+	if err := h.Allow(); err != nil {
+		t.Fatalf("err is %v after release", err)
+	}
+	h.MakeSuccess()
+	if stat := h.Stat(); stat.State != "closed" || stat.Successes != 1 || stat.Failures != 1 {
+		t.Fatalf("stat is %+v", stat)
+	}
+}
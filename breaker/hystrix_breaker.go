@@ -0,0 +1,147 @@
+package breaker
+
+import (
+	"conan/rolling"
+	"sync"
+	"time"
+)
+
+// hystrix 错误率或者连续失败的次数达到阈值时开启 开启后丢弃所有请求
+// 经过 SleepWindow 之后进入半开启 放行 HalfOpenRequests 个探测请求
+// 探测请求全部成功时关闭 有一个失败时重新开启
+type hystrix struct {
+	counter
+	conf *Config
+	now  func() time.Time
+
+	mu          sync.Mutex
+	stat        rolling.RollingCounter
+	state       int32
+	consecutive int64     // 连续失败的次数
+	openAt      time.Time // 开启的时间
+	probes      int64     // 半开启时 已经放行的探测请求数
+	probeOK     int64     // 半开启时 成功的探测请求数
+}
+
+func newHystrix(name string, conf *Config, now func() time.Time) *hystrix {
+	return &hystrix{
+		counter: counter{name: name, observer: conf.Observer},
+		conf:    conf,
+		now:     now,
+		stat:    newStat(conf, now),
+		state:   StateClosed,
+	}
+}
+
+// 需要持有锁 回调在锁外执行
+func (h *hystrix) setStateLocked(to int32) (from int32) {
+	from = h.state
+	h.state = to
+	switch to {
+	case StateOpen:
+		h.openAt = h.now()
+	case StateHalfOpen:
+		h.probes, h.probeOK = 0, 0
+	case StateClosed:
+		// 关闭之后 重新统计 避免开启之前的失败请求 导致再次开启
+		h.stat = newStat(h.conf, h.now)
+		h.consecutive = 0
+	}
+	return from
+}
+
+func (h *hystrix) Allow() error {
+	h.mu.Lock()
+	from := h.state
+	if h.state == StateOpen && h.now().Sub(h.openAt) >= h.conf.SleepWindow {
+		h.setStateLocked(StateHalfOpen)
+	}
+
+	var err error
+	switch h.state {
+	case StateOpen:
+		err = ErrServiceUnavailable
+	case StateHalfOpen:
+		if h.probes < h.conf.HalfOpenRequests {
+			h.probes++
+		} else {
+			err = ErrServiceUnavailable
+		}
+	}
+	to := h.state
+	h.mu.Unlock()
+
+	h.stateChange(from, to)
+	h.counter.allow(err)
+	return err
+}
+
+// State 返回当前的状态 StateOpen StateClosed StateHalfOpen
+func (h *hystrix) State() int32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// Stat 开启时 DropRatio 为1 半开启时只放行探测请求 也认为是1
+func (h *hystrix) Stat() Stat {
+	state := h.State()
+	dropRatio := 0.0
+	if state != StateClosed {
+		dropRatio = 1
+	}
+	return h.counter.stat(TypeHystrix, state, dropRatio)
+}
+
+func (h *hystrix) MakeSuccess() {
+	h.success()
+	h.mu.Lock()
+	from := h.state
+	h.stat.Add(1)
+	h.consecutive = 0
+	if h.state == StateHalfOpen {
+		h.probeOK++
+		if h.probeOK >= h.conf.HalfOpenRequests {
+			h.setStateLocked(StateClosed)
+		}
+	}
+	to := h.state
+	h.mu.Unlock()
+
+	h.stateChange(from, to)
+}
+
+func (h *hystrix) MakeFailed() {
+	h.failed()
+	h.mu.Lock()
+	from := h.state
+	h.stat.Add(0)
+	h.consecutive++
+	switch h.state {
+	case StateHalfOpen:
+		h.setStateLocked(StateOpen)
+	case StateClosed:
+		if h.consecutive >= h.conf.ConsecutiveFailures || h.overErrorPercent() {
+			h.setStateLocked(StateOpen)
+		}
+	}
+	to := h.state
+	h.mu.Unlock()
+
+	h.stateChange(from, to)
+}
+
+// Release 归还半开启时占用的探测名额 否则探测请求被取消后 会一直停留在半开启
+func (h *hystrix) Release() {
+	h.mu.Lock()
+	if h.state == StateHalfOpen && h.probes > h.probeOK {
+		h.probes--
+	}
+	h.mu.Unlock()
+}
+
+// 窗口内的请求数超过 Request 并且错误率达到 ErrorPercent
+func (h *hystrix) overErrorPercent() bool {
+	success, total := summary(h.stat)
+	return total >= h.conf.Request && (total-success)*100 >= h.conf.ErrorPercent*total
+}
@@ -1,7 +1,6 @@
 package breaker
 
 import (
-	"errors"
 	"conan/rolling"
 	"math"
 	"math/rand"
@@ -13,6 +12,7 @@ import (
 // SRE 阈值 requests ==  k * accepts  requests 小于该值的时候 不用熔断。 大于开始熔断
 // 熔断概率p =  max(0 , (requests - k * accepts) / requests+1)
 type sre struct {
+	counter
 	stat   rolling.RollingCounter
 	randMu sync.Mutex // 这里 random.Rand 不是线程安全的 所以要给锁
 	r      *rand.Rand
@@ -22,15 +22,10 @@ type sre struct {
 	state   int32
 }
 
-func newSre(conf *Config) *sre {
-	couterOpt := rolling.RollingCounterOpts{
-		Size:           conf.Bucket,
-		BucketDuration: time.Duration(int64(conf.Window) / int64(conf.Bucket)),
-	}
-	stat := rolling.NewRollingCounter(couterOpt)
-
+func newSre(name string, conf *Config) *sre {
 	return &sre{
-		stat:    stat,
+		counter: counter{name: name, observer: conf.Observer},
+		stat:    newStat(conf, nil),
 		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
 		k:       conf.K,
 		request: conf.Request,
@@ -39,18 +34,24 @@ func newSre(conf *Config) *sre {
 }
 
 func (s *sre) summary() (success int64, total int64) {
-	s.stat.Reduce(func(iterator rolling.Iterator) float64 {
-		for iterator.Next() {
-			b := iterator.Bucket()
-			total += b.Count
-
-			for _, p := range b.Point {
-				success += int64(p)
-			}
-		}
+	return summary(s.stat)
+}
+
+// 只有状态是 from 时才会修改 修改成功时回调 Observer
+func (s *sre) setState(from, to int32) {
+	if atomic.CompareAndSwapInt32(&s.state, from, to) {
+		s.stateChange(from, to)
+	}
+}
+
+// 丢弃请求的概率 为0时表示不需要熔断
+func (s *sre) dropRatio() float64 {
+	success, total := s.summary()
+	k := float64(success) * s.k
+	if total < s.request || float64(total) < k {
 		return 0
-	})
-	return
+	}
+	return math.Max(0, (float64(total)-k)/float64(total+1))
 }
 
 // 随机值 在 [0,p) 之间 表示需要丢弃  大于p表示不需要丢弃
@@ -63,38 +64,57 @@ func (s *sre) turnOnSre(p float64) (turnOn bool) {
 }
 
 func (s *sre) Allow() error {
-	success, total := s.summary()
+	err := s.allow()
+	s.counter.allow(err)
+	return err
+}
 
-	k := float64(success) * s.k
+func (s *sre) allow() error {
+	p := s.dropRatio()
 
 	// 这里表示不需要熔断 或者结束熔断
-	if total < s.request || float64(total) < k {
-		if atomic.LoadInt32(&s.state) == StateOpen {
-			atomic.CompareAndSwapInt32(&s.state, StateOpen, StateClosed)
-		}
+	if p == 0 {
+		// 成功率恢复之后 先进入半开启 由下一次请求的结果决定是否关闭
+		s.setState(StateOpen, StateHalfOpen)
 		return nil
 	}
 
 	// 这里表示需要打开
-	if atomic.LoadInt32(&s.state) == StateClosed {
-		atomic.CompareAndSwapInt32(&s.state, StateClosed, StateOpen)
+	if state := atomic.LoadInt32(&s.state); state != StateOpen {
+		s.setState(state, StateOpen)
 	}
 
-	p := math.Max(0, (float64(total)-k)/float64(total+1))
-
 	if s.turnOnSre(p) {
+		// 被丢弃的请求也计入总的请求数 调用方不需要再调用 MakeFailed
+		s.stat.Add(0)
 		// 返回 503 过载保护
-		return errors.New("service unavailable")
+		return ErrServiceUnavailable
 	}
 	return nil
 }
 
+// State 返回当前的状态 StateOpen StateClosed StateHalfOpen
+func (s *sre) State() int32 {
+	return atomic.LoadInt32(&s.state)
+}
+
+func (s *sre) Stat() Stat {
+	return s.counter.stat(TypeSRE, s.State(), s.dropRatio())
+}
+
 func (s *sre) MakeSuccess() {
+	s.success()
 	s.stat.Add(1)
+	s.setState(StateHalfOpen, StateClosed)
 }
 
 func (s *sre) MakeFailed() {
 	// 这里表示 本次需要熔断 上报的就是0 。在summary中 加success的值的时候 就会加到0
 	// 但是Count 的值还是会累计
+	s.failed()
 	s.stat.Add(0)
+	s.setState(StateHalfOpen, StateOpen)
 }
+
+// Release sre 没有占用名额 不需要处理
+func (s *sre) Release() {}
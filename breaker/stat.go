@@ -0,0 +1,84 @@
+package breaker
+
+import "sync/atomic"
+
+// Observer 熔断器状态变化 以及丢弃请求时回调 在请求的协程中执行 不能阻塞
+// name 为 Group 中的名字 直接使用 NewBreaker 创建时为空
+type Observer interface {
+	OnStateChange(name string, from, to int32)
+	OnReject(name string)
+}
+
+// Stat 熔断器的统计 除了 State 和 DropRatio 都是创建之后的累计值
+type Stat struct {
+	Name       string  `json:"name"`
+	Type       string  `json:"type"`
+	State      string  `json:"state"`
+	Requests   int64   `json:"requests"`   // 调用 Allow 的次数
+	Successes  int64   `json:"successes"`  // 调用 MakeSuccess 的次数
+	Failures   int64   `json:"failures"`   // 调用 MakeFailed 的次数
+	Rejections int64   `json:"rejections"` // Allow 返回错误的次数
+	DropRatio  float64 `json:"dropRatio"`  // 当前丢弃请求的概率
+}
+
+func StateName(state int32) string {
+	switch state {
+	case StateOpen:
+		return "open"
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// 每个熔断器的累计计数
+type counter struct {
+	name     string
+	observer Observer
+
+	requests   int64
+	successes  int64
+	failures   int64
+	rejections int64
+}
+
+func (c *counter) allow(err error) {
+	atomic.AddInt64(&c.requests, 1)
+	if err == nil {
+		return
+	}
+	atomic.AddInt64(&c.rejections, 1)
+	if c.observer != nil {
+		c.observer.OnReject(c.name)
+	}
+}
+
+func (c *counter) success() {
+	atomic.AddInt64(&c.successes, 1)
+}
+
+func (c *counter) failed() {
+	atomic.AddInt64(&c.failures, 1)
+}
+
+func (c *counter) stateChange(from, to int32) {
+	if from != to && c.observer != nil {
+		c.observer.OnStateChange(c.name, from, to)
+	}
+}
+
+func (c *counter) stat(typ string, state int32, dropRatio float64) Stat {
+	return Stat{
+		Name:       c.name,
+		Type:       typ,
+		State:      StateName(state),
+		Requests:   atomic.LoadInt64(&c.requests),
+		Successes:  atomic.LoadInt64(&c.successes),
+		Failures:   atomic.LoadInt64(&c.failures),
+		Rejections: atomic.LoadInt64(&c.rejections),
+		DropRatio:  dropRatio,
+	}
+}
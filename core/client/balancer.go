@@ -0,0 +1,120 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tau      = int64(600 * time.Millisecond) // EWMA 的衰减时间
+	forceGap = int64(3 * time.Second)        // 超过该时间没有被选中的节点 强制选中一次
+)
+
+// node 一个 BaseURL 和 p2c 中的 subConn 一样 记录延迟 成功率 以及正在处理的请求数
+type node struct {
+	base *url.URL
+	host string // 熔断器的名字
+
+	lag      int64 // 延迟的 EWMA 单位ns
+	success  int64 // 成功率的 EWMA 范围为 0-1000
+	inflight int64
+	stamp    int64 // 上次请求完成的时间
+	pick     int64 // 上次被选中的时间
+}
+
+func (n *node) health() int64 {
+	return atomic.LoadInt64(&n.success)
+}
+
+// load 值越大 说明负载越高 公式为 根号下lag * inflight
+func (n *node) load() int64 {
+	lag := int64(math.Sqrt(float64(atomic.LoadInt64(&n.lag)))) + 1
+	return lag * (atomic.LoadInt64(&n.inflight) + 1)
+}
+
+// done 请求完成之后 根据 EWMA 更新延迟以及成功率 公式为 Vt=βVt-1+(1−β)θt β = exp(-(now-stamp)/tau)
+func (n *node) done(start int64, failed bool) {
+	atomic.AddInt64(&n.inflight, -1)
+
+	now := time.Now().UnixNano()
+	oldStamp := atomic.SwapInt64(&n.stamp, now)
+	w := math.Exp(float64(-(now - oldStamp)) / float64(tau))
+
+	lag := now - start
+	if lag < 0 {
+		lag = 0
+	}
+	oldLag := atomic.LoadInt64(&n.lag)
+	if oldLag == 0 {
+		w = 0
+	}
+	atomic.StoreInt64(&n.lag, int64(float64(oldLag)*w+(1-w)*float64(lag)))
+
+	success := 1000.0
+	if failed {
+		success = 0
+	}
+	atomic.StoreInt64(&n.success, int64(float64(atomic.LoadInt64(&n.success))*w+(1-w)*success))
+}
+
+// release 请求被调用方取消 无法判断节点是否正常 只减少 inflight 不更新延迟以及成功率
+func (n *node) release() {
+	atomic.AddInt64(&n.inflight, -1)
+}
+
+type balancer struct {
+	nodes []*node
+
+	mu sync.Mutex // rand.Rand 不是线程安全的
+	r  *rand.Rand
+}
+
+func newBalancer(bases []*url.URL) *balancer {
+	b := &balancer{
+		nodes: make([]*node, 0, len(bases)),
+		r:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, base := range bases {
+		b.nodes = append(b.nodes, &node{
+			base:    base,
+			host:    base.Host,
+			success: 1000,
+		})
+	}
+	return b
+}
+
+// candidates 按照 p2c 随机选择两个节点 返回的第一个为负载较低的节点
+// allow 为 false 的节点(熔断中) 调用方会使用第二个节点
+func (b *balancer) candidates() []*node {
+	switch len(b.nodes) {
+	case 0:
+		return nil
+	case 1:
+		return b.nodes
+	}
+
+	b.mu.Lock()
+	i := b.r.Intn(len(b.nodes))
+	j := b.r.Intn(len(b.nodes) - 1)
+	b.mu.Unlock()
+	if j >= i {
+		j++
+	}
+	pc, upc := b.nodes[i], b.nodes[j]
+	// 负载高 或者成功率低的节点放在后面
+	if pc.load()*upc.health() > upc.load()*pc.health() {
+		pc, upc = upc, pc
+	}
+
+	// 负载较高的节点 超过 forceGap 没有被选中时 强制选中一次 用于更新它的统计
+	now := time.Now().UnixNano()
+	if pick := atomic.LoadInt64(&upc.pick); now-pick > forceGap && atomic.CompareAndSwapInt64(&upc.pick, pick, now) {
+		return []*node{upc, pc}
+	}
+	return []*node{pc, upc}
+}
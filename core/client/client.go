@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"conan/breaker"
+	"conan/core/server/binding"
+	"conan/core/server/rending"
+	"conan/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNoBaseURL = errors.New("client: no base url")
+
+	defaultConf = &Config{}
+)
+
+type Config struct {
+	// 服务的地址 例如 http://10.0.0.1:8080 多个地址时按照 p2c 选择
+	// 为空时 请求的 Path 需要是完整的url
+	BaseURLs []string `yaml:"baseURLs"`
+	// 单次请求的超时时间 单位ms 为0时为5000 重试时每次请求单独计算
+	Timeout int64 `yaml:"timeout"`
+	// 幂等的请求(GET HEAD OPTIONS PUT DELETE) 失败时的重试次数 为0时不重试
+	Retries int `yaml:"retries"`
+	// 第一次重试前等待的时间 单位ms 为0时为20 之后每次翻倍 最多等待1s
+	RetryBackoff int64 `yaml:"retryBackoff"`
+	// 每个host 最多保持的空闲连接数 为0时为32
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+	// 每个host 一个熔断器 为空时使用 breaker 的默认配置
+	Breaker *breaker.Config `yaml:"breaker"`
+}
+
+func (c *Config) fix() {
+	if c.Timeout == 0 {
+		c.Timeout = 5000
+	}
+	if c.RetryBackoff == 0 {
+		c.RetryBackoff = 20
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = 32
+	}
+}
+
+// StatusError 服务端返回的状态码不是 2xx
+type StatusError struct {
+	Code int
+	Body []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("client: response status is %d , body is %s", e.Code, e.Body)
+}
+
+// CodeError 服务端返回的 rending.Json 中 Code 不为0
+type CodeError struct {
+	Code int64
+	Msg  string
+}
+
+func (e *CodeError) Error() string {
+	return fmt.Sprintf("client: response code is %d , msg is %s", e.Code, e.Msg)
+}
+
+// Request 一次请求 Body 在重试时会重复使用
+type Request struct {
+	Method  string
+	Path    string // 相对于 BaseURL 的路径 没有配置 BaseURLs 时为完整的url
+	Query   url.Values
+	Header  http.Header
+	Body    []byte
+	Timeout time.Duration // 单次请求的超时时间 为0时使用 Config.Timeout
+}
+
+// Client 调用一个下游服务 可以被多个协程同时使用
+type Client struct {
+	conf     *Config
+	cli      *http.Client
+	balancer *balancer
+	breakers *breaker.Group
+
+	mu sync.Mutex // rand.Rand 不是线程安全的
+	r  *rand.Rand
+}
+
+// NewClient conf 为空时使用默认配置 BaseURLs 格式错误时返回错误
+func NewClient(conf *Config) (*Client, error) {
+	if conf == nil {
+		conf = defaultConf
+	}
+	c := *conf
+	c.fix()
+
+	bases := make([]*url.URL, 0, len(c.BaseURLs))
+	for _, s := range c.BaseURLs {
+		base, err := url.Parse(strings.TrimSuffix(s, "/"))
+		if err != nil {
+			return nil, err
+		}
+		if base.Scheme == "" || base.Host == "" {
+			return nil, fmt.Errorf("client: invalid base url %s", s)
+		}
+		bases = append(bases, base)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	return &Client{
+		conf:     &c,
+		cli:      &http.Client{Transport: transport},
+		balancer: newBalancer(bases),
+		breakers: breaker.NewGroup(c.Breaker),
+		r:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Breakers 每个host的熔断器 可以注册到 server.Engine 中查看状态
+func (c *Client) Breakers() *breaker.Group {
+	return c.breakers
+}
+
+// Close 关闭空闲的连接
+func (c *Client) Close() {
+	c.cli.CloseIdleConnections()
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// 网络错误 以及 5xx 需要重试 也会计入熔断器的失败
+func failed(res *http.Response, err error) bool {
+	return err != nil || res.StatusCode >= http.StatusInternalServerError
+}
+
+// Do 发送请求 成功时调用方需要关闭 Body
+// 熔断以及 ctx 结束时不会重试 幂等的请求在网络错误以及 5xx 时会重试 Config.Retries 次
+func (c *Client) Do(ctx context.Context, req *Request) (*http.Response, error) {
+	retries := 0
+	if idempotent(req.Method) {
+		retries = c.conf.Retries
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.do(ctx, req)
+		if !failed(res, err) || attempt >= retries || errors.Is(err, breaker.ErrServiceUnavailable) || ctx.Err() != nil {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 指数退避 在 [0,backoff) 之间随机 避免所有的客户端同时重试
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := time.Duration(c.conf.RetryBackoff) * time.Millisecond << uint(attempt)
+	if backoff > time.Second || backoff <= 0 {
+		backoff = time.Second
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Duration(c.r.Int63n(int64(backoff))) + 1
+}
+
+// 选择一个没有熔断的节点 发送一次请求
+func (c *Client) do(ctx context.Context, req *Request) (*http.Response, error) {
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = time.Duration(c.conf.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+	// 先创建请求 选择节点之后再设置url 熔断器 Allow 之后一定会上报结果
+	r, err := http.NewRequestWithContext(ctx, req.Method, req.Path, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if len(req.Query) > 0 {
+		if r.URL.RawQuery != "" {
+			r.URL.RawQuery += "&"
+		}
+		r.URL.RawQuery += req.Query.Encode()
+	}
+
+	n, b, err := c.pick(r.URL)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if n != nil {
+		target := *n.base
+		target.Path = n.base.Path + "/" + strings.TrimPrefix(r.URL.Path, "/")
+		target.RawQuery = r.URL.RawQuery
+		r.URL = &target
+		r.Host = target.Host
+		atomic.AddInt64(&n.inflight, 1)
+		atomic.StoreInt64(&n.pick, time.Now().UnixNano())
+	}
+
+	start := time.Now().UnixNano()
+	res, err := c.cli.Do(r)
+	// 调用方主动取消的请求 不计入熔断器以及 p2c 的统计
+	if errors.Is(err, context.Canceled) {
+		if n != nil {
+			n.release()
+		}
+		b.Release()
+		cancel()
+		return nil, err
+	}
+	fail := failed(res, err)
+	if n != nil {
+		n.done(start, fail)
+	}
+	if fail {
+		b.MakeFailed()
+	} else {
+		b.MakeSuccess()
+	}
+
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// 读完 Body 之后再取消 ctx
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// pick 按照 p2c 选择一个熔断器允许的节点 没有配置 BaseURLs 时使用 target 的 host
+func (c *Client) pick(target *url.URL) (*node, breaker.Breaker, error) {
+	candidates := c.balancer.candidates()
+	if len(candidates) == 0 {
+		if target.Host == "" {
+			return nil, nil, ErrNoBaseURL
+		}
+		b := c.breakers.Get(target.Host)
+		if err := b.Allow(); err != nil {
+			return nil, nil, err
+		}
+		return nil, b, nil
+	}
+
+	err := breaker.ErrServiceUnavailable
+	for _, n := range candidates {
+		b := c.breakers.Get(n.host)
+		if err = b.Allow(); err == nil {
+			return n, b, nil
+		}
+	}
+	return nil, nil, err
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Get query 会进行url编码 返回的 rending.Json 中的 Data 解析到 resp 中
+func (c *Client) Get(ctx context.Context, path string, query url.Values, resp interface{}) error {
+	return c.call(ctx, &Request{Method: http.MethodGet, Path: path, Query: query}, resp)
+}
+
+// PostJson data 编码为json 返回的 rending.Json 中的 Data 解析到 resp 中
+func (c *Client) PostJson(ctx context.Context, path string, data interface{}, resp interface{}) error {
+	b, err := utils.Json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{binding.MEINJSON}}
+	return c.call(ctx, &Request{Method: http.MethodPost, Path: path, Header: header, Body: b}, resp)
+}
+
+// PostForm form 编码为 application/x-www-form-urlencoded 返回的 rending.Json 中的 Data 解析到 resp 中
+func (c *Client) PostForm(ctx context.Context, path string, form url.Values, resp interface{}) error {
+	header := http.Header{"Content-Type": []string{binding.MEINPOSTFORM}}
+	return c.call(ctx, &Request{Method: http.MethodPost, Path: path, Header: header, Body: []byte(form.Encode())}, resp)
+}
+
+// call 状态码不是 2xx 时返回 *StatusError Code 不为0时返回 *CodeError resp 为空时不解析
+func (c *Client) call(ctx context.Context, req *Request, resp interface{}) error {
+	res, err := c.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &StatusError{Code: res.StatusCode, Body: b}
+	}
+
+	result := &rending.Json{Data: resp}
+	if len(b) > 0 {
+		if err := utils.Json.Unmarshal(b, result); err != nil {
+			return err
+		}
+	}
+	if result.Code != 0 {
+		return &CodeError{Code: result.Code, Msg: result.Msg}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package client
+
+import (
+	"conan/breaker"
+	"conan/core/server/rending"
+	"conan/utils"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeJson(w http.ResponseWriter, data interface{}) {
+	b, _ := utils.Json.Marshal(&rending.Json{Data: data})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func TestGetAndPost(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/query":
+			writeJson(w, r.URL.Query().Get("q"))
+		case "/api/json":
+			b, _ := ioutil.ReadAll(r.Body)
+			writeJson(w, r.Header.Get("Content-Type")+" "+string(b))
+		case "/api/form":
+			r.ParseForm()
+			writeJson(w, r.PostForm.Get("name"))
+		case "/api/code":
+			b, _ := utils.Json.Marshal(&rending.Json{Code: 10, Msg: "not found"})
+			w.Write(b)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(&Config{BaseURLs: []string{srv.URL + "/api/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// query 需要url编码
+	var s string
+	if err := c.Get(ctx, "/query", url.Values{"q": {"a&b=c d"}}, &s); err != nil || s != "a&b=c d" {
+		t.Fatalf("resp is %s , err is %v", s, err)
+	}
+	if err := c.PostJson(ctx, "json", struct {
+		A int `json:"a"`
+	}{1}, &s); err != nil || s != `application/json {"a":1}` {
+		t.Fatalf("resp is %s , err is %v", s, err)
+	}
+	if err := c.PostForm(ctx, "/form", url.Values{"name": {"conan"}}, &s); err != nil || s != "conan" {
+		t.Fatalf("resp is %s , err is %v", s, err)
+	}
+
+	var codeErr *CodeError
+	if err := c.Get(ctx, "/code", nil, nil); !errors.As(err, &codeErr) || codeErr.Code != 10 {
+		t.Fatalf("err is %v", err)
+	}
+	var statusErr *StatusError
+	if err := c.Get(ctx, "/none", nil, nil); !errors.As(err, &statusErr) || statusErr.Code != http.StatusNotFound {
+		t.Fatalf("err is %v", err)
+	}
+
+	// 没有配置 BaseURLs 时使用完整的url
+	c, _ = NewClient(nil)
+	if err := c.Get(ctx, srv.URL+"/api/query?q=1", nil, &s); err != nil || s != "1" {
+		t.Fatalf("resp is %s , err is %v", s, err)
+	}
+	if err := c.Get(ctx, "/api/query", nil, &s); err != ErrNoBaseURL {
+		t.Fatalf("err is %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeJson(w, "ok")
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&Config{BaseURLs: []string{srv.URL}, Retries: 2, RetryBackoff: 1})
+	var s string
+	if err := c.Get(context.Background(), "/", nil, &s); err != nil || s != "ok" || calls != 3 {
+		t.Fatalf("resp is %s , err is %v , calls is %d", s, err, calls)
+	}
+
+	// 非幂等的请求不重试
+	atomic.StoreInt64(&calls, 0)
+	if err := c.PostJson(context.Background(), "/", nil, &s); err == nil || calls != 1 {
+		t.Fatalf("err is %v , calls is %d", err, calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&Config{BaseURLs: []string{srv.URL}})
+	res, err := c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/", Timeout: 20 * time.Millisecond})
+	if err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("res is %v , err is %v", res, err)
+	}
+}
+
+func TestBalance(t *testing.T) {
+	var good, failed int64
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&good, 1)
+		writeJson(w, "ok")
+	}))
+	defer ok.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&failed, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	c, _ := NewClient(&Config{BaseURLs: []string{ok.URL, bad.URL}})
+	for i := 0; i < 50; i++ {
+		c.Get(context.Background(), "/", nil, nil)
+	}
+	// 失败之后成功率下降 p2c 会选择正常的节点
+	if good+failed != 50 || failed > 3 {
+		t.Fatalf("good is %d , failed is %d", good, failed)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	var calls int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	c, _ := NewClient(&Config{
+		BaseURLs: []string{bad.URL},
+		Retries:  5,
+		Breaker:  &breaker.Config{Type: breaker.TypeHystrix, ConsecutiveFailures: 3, SleepWindow: time.Minute},
+	})
+	// 连续失败3次之后熔断 不再重试
+	if err := c.Get(context.Background(), "/", nil, nil); err != breaker.ErrServiceUnavailable {
+		t.Fatalf("err is %v", err)
+	}
+	if err := c.Get(context.Background(), "/", nil, nil); err != breaker.ErrServiceUnavailable || calls != 3 {
+		t.Fatalf("err is %v , calls is %d", err, calls)
+	}
+
+	host, _ := url.Parse(bad.URL)
+	if stat := c.Breakers().Get(host.Host).Stat(); stat.State != "open" || stat.Failures != 3 || stat.Rejections != 2 {
+		t.Fatalf("stat is %+v", stat)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&Config{BaseURLs: []string{srv.URL}})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := c.Get(ctx, "/", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err is %v", err)
+	}
+
+	// 取消的请求 不计入熔断器 也不影响节点的成功率
+	host, _ := url.Parse(srv.URL)
+	if stat := c.Breakers().Get(host.Host).Stat(); stat.Requests != 1 || stat.Successes != 0 || stat.Failures != 0 {
+		t.Fatalf("stat is %+v", stat)
+	}
+	n := c.balancer.nodes[0]
+	if n.health() != 1000 || n.inflight != 0 {
+		t.Fatalf("health is %d , inflight is %d", n.health(), n.inflight)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"conan/p2c"
+	cpustate "conan/sys/cpu"
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func cpuUsage() uint64 {
+	s := cpustate.State{}
+	cpustate.ReadState(&s)
+	return s.Usage
+}
+
+// 使用率为0时 说明还没有采集到 不设置 trailer p2c 会保留之前的值
+func cpuTrailer(usage uint64) metadata.MD {
+	if usage == 0 {
+		return nil
+	}
+	return metadata.Pairs(p2c.CPUUsage, strconv.FormatUint(usage, 10))
+}
+
+// CPUTrailer 在 trailer 中返回当前的cpu使用率(千分比) 客户端的 p2c 会选择cpu使用率较低的节点
+// usage 为空时使用 sys/cpu 采集的使用率 Server 已经内置 直接使用 grpc.Server 时可以单独添加
+func CPUTrailer(usage func() uint64) grpc.UnaryServerInterceptor {
+	if usage == nil {
+		usage = cpuUsage
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if md := cpuTrailer(usage()); md != nil {
+			grpc.SetTrailer(ctx, md)
+		}
+		return resp, err
+	}
+}
+
+// StreamCPUTrailer 和 CPUTrailer 一样 在流结束时返回cpu使用率
+func StreamCPUTrailer(usage func() uint64) grpc.StreamServerInterceptor {
+	if usage == nil {
+		usage = cpuUsage
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if md := cpuTrailer(usage()); md != nil {
+			ss.SetTrailer(md)
+		}
+		return err
+	}
+}
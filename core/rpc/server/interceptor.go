@@ -0,0 +1,173 @@
+package server
+
+import (
+	"conan/bbr"
+	"conan/log"
+	"context"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// MetadataPriority 上游设置的请求优先级 low normal high 和 http 的 X-Priority 一致 开启 TrustPriority 时才使用
+	MetadataPriority = "x-priority"
+
+	healthService = "/grpc.health.v1.Health/"
+)
+
+// unaryInterceptor 内置的拦截器按照 recovery 日志 限流 超时 cpu trailer 的顺序执行 之后执行 Use 添加的拦截器
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	start := time.Now()
+	var done func(bbr.DoneInfo)
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverErr(info.FullMethod, r)
+		}
+		// 在 recovery 之后上报 panic 的请求也会计入 Drop
+		if done != nil {
+			done(doneInfo(ctx, err))
+		}
+		accessLog(ctx, info.FullMethod, "unary", start, err)
+	}()
+
+	if done, err = s.allow(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	// 超时使用单独的 ctx 上报限流结果时 需要判断的是客户端是否取消
+	tctx := ctx
+	if timeout := s.timeout(info.FullMethod); timeout > 0 {
+		var cancel context.CancelFunc
+		tctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	resp, err = chainUnary(s.unary, info, handler)(tctx, req)
+	if md := cpuTrailer(s.cpu()); md != nil {
+		grpc.SetTrailer(ctx, md)
+	}
+	return resp, err
+}
+
+// streamInterceptor 和 unary 一样 但不设置超时 流的持续时间由业务决定
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	ctx := ss.Context()
+	start := time.Now()
+	var done func(bbr.DoneInfo)
+	defer func() {
+		if r := recover(); r != nil {
+			err = recoverErr(info.FullMethod, r)
+		}
+		if done != nil {
+			done(doneInfo(ctx, err))
+		}
+		accessLog(ctx, info.FullMethod, "stream", start, err)
+	}()
+
+	if done, err = s.allow(ctx, info.FullMethod); err != nil {
+		return err
+	}
+
+	err = chainStream(s.stream, info, handler)(srv, ss)
+	if md := cpuTrailer(s.cpu()); md != nil {
+		ss.SetTrailer(md)
+	}
+	return err
+}
+
+func chainUnary(interceptors []grpc.UnaryServerInterceptor, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) grpc.UnaryHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler
+}
+
+func chainStream(interceptors []grpc.StreamServerInterceptor, info *grpc.StreamServerInfo, handler grpc.StreamHandler) grpc.StreamHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(srv interface{}, ss grpc.ServerStream) error {
+			return interceptor(srv, ss, info, next)
+		}
+	}
+	return handler
+}
+
+func recoverErr(method string, r interface{}) error {
+	buf := make([]byte, 64<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	log.Error("RPC Panic method is %s err is %v\n%s", method, r, buf)
+	return status.Errorf(codes.Internal, "panic: %v", r)
+}
+
+func accessLog(ctx context.Context, method, kind string, start time.Time, err error) {
+	addr := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	st := status.Convert(err)
+	log.InfoFields("rpc",
+		zap.String("method", method),
+		zap.String("kind", kind),
+		zap.String("code", st.Code().String()),
+		zap.String("error", st.Message()),
+		zap.Duration("latency", time.Since(start)),
+		zap.String("ip", addr),
+	)
+}
+
+func noopDone(bbr.DoneInfo) {}
+
+// allow 未开启限流以及健康检查时直接通过 过载时返回 ResourceExhausted
+func (s *Server) allow(ctx context.Context, method string) (func(bbr.DoneInfo), error) {
+	if s.limiter == nil || strings.HasPrefix(method, healthService) {
+		return noopDone, nil
+	}
+
+	if _, ok := bbr.PriorityFromContext(ctx); !ok && s.conf.TrustPriority {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(MetadataPriority); len(v) > 0 {
+				ctx = bbr.WithPriority(ctx, bbr.ParsePriority(v[0]))
+			}
+		}
+	}
+
+	done, err := s.limiter.Allow(ctx)
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return done, nil
+}
+
+// 只有正常处理完成的请求 才会用于计算系统的处理能力
+func doneInfo(ctx context.Context, err error) bbr.DoneInfo {
+	if ctx.Err() == context.Canceled {
+		// 客户端主动断开 无法判断服务端的处理情况
+		return bbr.DoneInfo{Op: bbr.Ignore, Err: ctx.Err()}
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Internal, codes.Unavailable:
+		return bbr.DoneInfo{Op: bbr.Drop, Err: err}
+	case codes.Canceled:
+		return bbr.DoneInfo{Op: bbr.Ignore, Err: err}
+	}
+	return bbr.DoneInfo{Op: bbr.Success}
+}
+
+// timeout 方法单独配置的超时时间优先 客户端的 deadline 更短时 context.WithTimeout 会保留客户端的
+func (s *Server) timeout(method string) time.Duration {
+	if timeout, ok := s.timeouts[strings.ToLower(method)]; ok {
+		return timeout
+	}
+	return time.Duration(s.conf.Timeout) * time.Millisecond
+}
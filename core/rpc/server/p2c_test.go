@@ -0,0 +1,108 @@
+package server
+
+import (
+	"conan/p2c"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// backend 一个 bufconn 上的 Server cpu 为 trailer 中返回的使用率 每个请求额外延迟 lag
+type backend struct {
+	lis   *bufconn.Listener
+	count int64
+}
+
+func newBackend(t *testing.T, cpu uint64, lag time.Duration) *backend {
+	b := &backend{lis: bufconn.Listen(1 << 20)}
+	s := NewServer(&ServerConfig{}).Use(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt64(&b.count, 1)
+		time.Sleep(lag)
+		return handler(ctx, req)
+	})
+	s.cpu = func() uint64 { return cpu }
+	s.Server().RegisterService(&testDesc, testService{})
+	if err := s.Serve(b.lis); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return b
+}
+
+func (b *backend) dial(t *testing.T) *grpc.ClientConn {
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return b.lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestCPUTrailer(t *testing.T) {
+	for cpu, want := range map[uint64][]string{350: {"350"}, 0: nil} {
+		conn := newBackend(t, cpu, 0).dial(t)
+		var trailer metadata.MD
+		if err := conn.Invoke(context.Background(), "/test.Test/Sleep", &healthpb.HealthCheckRequest{Service: "0s"}, new(healthpb.HealthCheckResponse), grpc.Trailer(&trailer)); err != nil {
+			t.Fatal(err)
+		}
+		// 没有采集到cpu使用率时 不设置 trailer
+		if v := trailer.Get(p2c.CPUUsage); len(v) != len(want) || (len(v) == 1 && v[0] != want[0]) {
+			t.Fatalf("cpu is %d , trailer is %v", cpu, trailer)
+		}
+	}
+}
+
+// p2c 根据 trailer 中的cpu使用率 将请求发送到负载较低的节点
+// idle 的延迟更高 只看延迟时会选择 busy
+func TestP2C(t *testing.T) {
+	busy, idle := newBackend(t, 900, time.Millisecond), newBackend(t, 100, 4*time.Millisecond)
+	listeners := map[string]*bufconn.Listener{"busy": busy.lis, "idle": idle.lis}
+
+	r := manual.NewBuilderWithScheme("test")
+	r.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: "busy"}, {Addr: "idle"}}})
+	conn, err := grpc.Dial(r.Scheme()+":///backend",
+		grpc.WithInsecure(),
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy":"`+p2c.Name+`"}`),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return listeners[addr].Dial()
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 两个节点都收到请求之后 p2c 才拿到了两个节点的cpu使用率
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&busy.count) == 0 || atomic.LoadInt64(&idle.count) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("busy is %d , idle is %d", busy.count, idle.count)
+		}
+		if err := sleep(context.Background(), conn, "0s"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	atomic.StoreInt64(&busy.count, 0)
+	atomic.StoreInt64(&idle.count, 0)
+	for i := 0; i < 100; i++ {
+		if err := sleep(context.Background(), conn, "0s"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b, i := atomic.LoadInt64(&busy.count), atomic.LoadInt64(&idle.count); b > 10 || b+i != 100 {
+		t.Fatalf("busy is %d , idle is %d", b, i)
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"conan/bbr"
+	"conan/config"
+	"conan/log"
+	cpustate "conan/sys/cpu"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	CLOSED  = 1
+	START   = 2
+	STOPPED = 3 // Shutdown 之后的状态 不能再次启动
+)
+
+var (
+	defaultConf = &ServerConfig{}
+
+	ErrServerRunning = errors.New("rpc server is already running")
+	ErrServerStopped = errors.New("rpc server is stopped")
+)
+
+type ServerConfig struct {
+	Network string `yaml:"network"`
+	Addr    string `yaml:"addr"`
+	// 每个请求默认的超时时间 单位ms 为0时不限制 客户端传递的超时时间更短时 使用客户端的
+	Timeout int64 `yaml:"timeout"`
+	// 单独设置某些方法的超时时间 单位ms key 为完整的方法名 eg: /helloworld.Greeter/SayHello 不区分大小写
+	MethodTimeouts map[string]int64 `yaml:"methodTimeouts"`
+	// 关闭时 等待正在处理的请求完成的最长时间 单位s 为0时一直等待
+	ShutdownTimeout int64 `yaml:"shutdownTimeout"`
+	// 连接空闲多久之后关闭 单位s 为0时不关闭
+	IdleTimeout int64 `yaml:"idleTimeout"`
+	// 连接最长的存活时间 单位s 为0时不限制 到期后客户端会重新连接 可以让负载更均衡
+	MaxConnectionAge int64 `yaml:"maxConnectionAge"`
+	// 是否开启bbr自适应限流 使用bbr的默认配置 需要调用 bbr.Init
+	BBR bool `yaml:"bbr"`
+	// 是否使用客户端 metadata 中的 x-priority 作为限流的优先级 只有上游可信(例如内网网关)时才开启 否则客户端可以随意提升优先级
+	TrustPriority bool `yaml:"trustPriority"`
+}
+
+func init() {
+	// 没有配置 rpcServer 时使用默认配置 不影响只使用http的服务
+	if err := config.Decode(config.CONFIG_FILE_NAME, "rpcServer", defaultConf); err != nil {
+		log.Warn("RPC Server Load Config Fail err is %s", err.Error())
+	}
+}
+
+// Server 对 grpc.Server 的封装 内置 recovery 日志 限流 超时 cpu trailer 以及健康检查
+// 注册服务使用 Server() 需要在 Start 之前完成
+type Server struct {
+	conf     *ServerConfig
+	timeouts map[string]time.Duration // key 为小写的方法名 viper 读取配置时会把 key 转为小写
+	server   *grpc.Server
+	health   *health.Server
+	limiter  bbr.Limiter   // 未开启限流时为nil
+	cpu      func() uint64 // 在 trailer 中返回的cpu使用率
+
+	unary  []grpc.UnaryServerInterceptor  // 通过 Use 添加的拦截器 在内置的拦截器之后执行
+	stream []grpc.StreamServerInterceptor // 通过 UseStream 添加的拦截器
+
+	mu     sync.Mutex
+	lis    net.Listener
+	closed int32
+	done   chan struct{} // Serve 返回之后关闭
+}
+
+// NewServer conf 为空时使用配置文件中的 rpcServer
+// opts 会传递给 grpc.NewServer 不能包含拦截器 拦截器使用 Use UseStream 添加
+func NewServer(conf *ServerConfig, opts ...grpc.ServerOption) *Server {
+	if conf == nil {
+		conf = defaultConf
+	}
+
+	s := &Server{
+		conf:     conf,
+		timeouts: make(map[string]time.Duration, len(conf.MethodTimeouts)),
+		health:   health.NewServer(),
+		cpu:      cpuUsage,
+		closed:   CLOSED,
+	}
+	for method, ms := range conf.MethodTimeouts {
+		s.timeouts[strings.ToLower(method)] = time.Duration(ms) * time.Millisecond
+	}
+	if conf.BBR {
+		s.limiter = bbr.NewLimiter(nil)
+	}
+	// 开始采集cpu使用率 用于 p2c 的 trailer
+	if err := cpustate.Init(); err != nil {
+		log.Warn("RPC Server Init CPU Fail err is %s", err.Error())
+	}
+
+	keepParam := keepalive.ServerParameters{
+		MaxConnectionIdle: time.Duration(conf.IdleTimeout) * time.Second,
+		MaxConnectionAge:  time.Duration(conf.MaxConnectionAge) * time.Second,
+	}
+	opts = append([]grpc.ServerOption{
+		grpc.KeepaliveParams(keepParam),
+		grpc.UnaryInterceptor(s.unaryInterceptor),
+		grpc.StreamInterceptor(s.streamInterceptor),
+	}, opts...)
+	s.server = grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	return s
+}
+
+// Server 返回 grpc.Server 用于注册服务
+func (s *Server) Server() *grpc.Server {
+	return s.server
+}
+
+// Health 健康检查服务 可以单独设置每个服务的状态
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// Use 添加 unary 拦截器 需要在 Start 之前调用
+func (s *Server) Use(interceptors ...grpc.UnaryServerInterceptor) *Server {
+	s.unary = append(s.unary, interceptors...)
+	return s
+}
+
+// UseStream 添加 stream 拦截器 需要在 Start 之前调用
+func (s *Server) UseStream(interceptors ...grpc.StreamServerInterceptor) *Server {
+	s.stream = append(s.stream, interceptors...)
+	return s
+}
+
+// Start 会同步的监听 Addr 监听失败时直接返回错误 成功后在后台处理请求
+func (s *Server) Start() error {
+	network := s.conf.Network
+	if network == "" {
+		network = "tcp"
+	}
+	lis, err := net.Listen(network, s.conf.Addr)
+	if err != nil {
+		log.Error("RPC Server Listen Fail err is %s", err.Error())
+		return err
+	}
+	if err := s.Serve(lis); err != nil {
+		lis.Close()
+		return err
+	}
+	return nil
+}
+
+// Serve 在后台处理 lis 上的请求 测试时可以传入 bufconn
+func (s *Server) Serve(lis net.Listener) error {
+	if !atomic.CompareAndSwapInt32(&s.closed, CLOSED, START) {
+		if atomic.LoadInt32(&s.closed) == STOPPED {
+			return ErrServerStopped
+		}
+		return ErrServerRunning
+	}
+
+	s.mu.Lock()
+	s.lis = lis
+	s.done = make(chan struct{})
+	done := s.done
+	s.mu.Unlock()
+
+	s.health.Resume()
+	go func() {
+		defer close(done)
+		if err := s.server.Serve(lis); err != nil {
+			log.Error("RPC Server Serve Fail err is %s", err.Error())
+		}
+	}()
+	log.Info("RPC Server Start addr is %s", lis.Addr().String())
+	return nil
+}
+
+// Addr 正在监听的地址 未启动时为nil
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lis == nil {
+		return nil
+	}
+	return s.lis.Addr()
+}
+
+// Shutdown 先将健康检查设置为 NOT_SERVING 然后等待正在处理的请求完成
+// 超过 ShutdownTimeout 或者 ctx 结束时 直接关闭所有连接 并返回 ctx 的错误
+// 关闭之后不能再次启动
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s.closed, START, STOPPED) {
+		return nil
+	}
+
+	if s.conf.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.conf.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
+
+	s.health.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		// 这里表示超时了 还有请求没有处理完
+		err = ctx.Err()
+		s.server.Stop()
+		<-stopped
+	}
+
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	<-done
+	log.Info("RPC Server Stop")
+	return err
+}
+
+func (s *Server) Close() {
+	if err := s.Shutdown(context.Background()); err != nil {
+		log.Error("RPC Server Close Err is %s", err.Error())
+	}
+}
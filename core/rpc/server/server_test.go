@@ -0,0 +1,239 @@
+package server
+
+import (
+	"conan/bbr"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// 测试用的服务 复用健康检查的消息 避免生成代码
+type testService struct{}
+
+func (testService) Panic(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	panic("boom")
+}
+
+// Sleep 睡眠 Service 中指定的时间 ctx 结束时返回
+func (testService) Sleep(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	d, err := time.ParseDuration(req.Service)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	select {
+	case <-time.After(d):
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+func unaryHandler(method func(testService, context.Context, *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error), name string) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := new(healthpb.HealthCheckRequest)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return method(srv.(testService), ctx, req.(*healthpb.HealthCheckRequest))
+			}
+			return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.Test/" + name}, handler)
+		},
+	}
+}
+
+var testDesc = grpc.ServiceDesc{
+	ServiceName: "test.Test",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler(testService.Panic, "Panic"),
+		unaryHandler(testService.Sleep, "Sleep"),
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "StreamPanic",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			panic("stream boom")
+		},
+	}},
+}
+
+func startServer(t *testing.T, conf *ServerConfig, interceptors ...grpc.UnaryServerInterceptor) (*Server, *grpc.ClientConn) {
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(conf).Use(interceptors...)
+	s.Server().RegisterService(&testDesc, testService{})
+	if err := s.Serve(lis); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Close()
+	})
+	return s, conn
+}
+
+func sleep(ctx context.Context, conn *grpc.ClientConn, d string) error {
+	return conn.Invoke(ctx, "/test.Test/Sleep", &healthpb.HealthCheckRequest{Service: d}, new(healthpb.HealthCheckResponse))
+}
+
+func TestInterceptor(t *testing.T) {
+	var calls []string
+	conf := &ServerConfig{Timeout: 1000, MethodTimeouts: map[string]int64{"/Test.Test/Sleep": 50}}
+	_, conn := startServer(t, conf, func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		calls = append(calls, info.FullMethod)
+		return handler(ctx, req)
+	})
+
+	res, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("res is %v , err is %v", res, err)
+	}
+
+	// panic 时返回 Internal
+	err = conn.Invoke(context.Background(), "/test.Test/Panic", &healthpb.HealthCheckRequest{}, new(healthpb.HealthCheckResponse))
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("err is %v", err)
+	}
+	stream, err := conn.NewStream(context.Background(), &testDesc.Streams[0], "/test.Test/StreamPanic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.CloseSend()
+	if err := stream.RecvMsg(new(healthpb.HealthCheckResponse)); status.Code(err) != codes.Internal {
+		t.Fatalf("err is %v", err)
+	}
+
+	// 方法单独配置的超时时间
+	if err := sleep(context.Background(), conn, "10ms"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sleep(context.Background(), conn, "200ms"); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("err is %v", err)
+	}
+
+	if len(calls) != 4 || calls[0] != "/grpc.health.v1.Health/Check" {
+		t.Fatalf("calls is %v", calls)
+	}
+}
+
+// spyLimiter 记录每个请求上报的 Op 以及请求的优先级
+type spyLimiter struct {
+	mu    sync.Mutex
+	ops   []bbr.Op
+	prios []bbr.Priority // 没有设置优先级时为 -1
+}
+
+func (l *spyLimiter) Allow(ctx context.Context) (func(bbr.DoneInfo), error) {
+	p, ok := bbr.PriorityFromContext(ctx)
+	if !ok {
+		p = -1
+	}
+	l.mu.Lock()
+	l.prios = append(l.prios, p)
+	l.mu.Unlock()
+	return func(info bbr.DoneInfo) {
+		l.mu.Lock()
+		l.ops = append(l.ops, info.Op)
+		l.mu.Unlock()
+	}, nil
+}
+
+func (l *spyLimiter) last() bbr.Op {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.ops) == 0 {
+		return -1
+	}
+	return l.ops[len(l.ops)-1]
+}
+
+func TestLimiterDone(t *testing.T) {
+	limiter := &spyLimiter{}
+	s, conn := startServer(t, &ServerConfig{Timeout: 50})
+	s.limiter = limiter
+
+	if err := sleep(context.Background(), conn, "1ms"); err != nil || limiter.last() != bbr.Success {
+		t.Fatalf("err is %v , op is %d", err, limiter.last())
+	}
+	// 服务端超时 以及 panic 都需要上报 Drop
+	if err := sleep(context.Background(), conn, "200ms"); status.Code(err) != codes.DeadlineExceeded || limiter.last() != bbr.Drop {
+		t.Fatalf("err is %v , op is %d", err, limiter.last())
+	}
+	err := conn.Invoke(context.Background(), "/test.Test/Panic", &healthpb.HealthCheckRequest{}, new(healthpb.HealthCheckResponse))
+	if status.Code(err) != codes.Internal || limiter.last() != bbr.Drop {
+		t.Fatalf("err is %v , op is %d", err, limiter.last())
+	}
+}
+
+func TestTrustPriority(t *testing.T) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), MetadataPriority, "high")
+
+	// 默认不使用客户端传来的优先级
+	limiter := &spyLimiter{}
+	s, conn := startServer(t, &ServerConfig{})
+	s.limiter = limiter
+	if err := sleep(ctx, conn, "1ms"); err != nil || limiter.prios[0] != -1 {
+		t.Fatalf("err is %v , prios is %v", err, limiter.prios)
+	}
+
+	limiter = &spyLimiter{}
+	s, conn = startServer(t, &ServerConfig{TrustPriority: true})
+	s.limiter = limiter
+	if err := sleep(ctx, conn, "1ms"); err != nil || limiter.prios[0] != bbr.PriorityHigh {
+		t.Fatalf("err is %v , prios is %v", err, limiter.prios)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s, conn := startServer(t, &ServerConfig{})
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sleep(context.Background(), conn, "100ms")
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// 正在处理的请求 会等待完成
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("err is %v", err)
+	}
+
+	// 关闭之后不能再次启动
+	if err := s.Serve(bufconn.Listen(1 << 20)); err != ErrServerStopped {
+		t.Fatalf("err is %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, conn := startServer(t, &ServerConfig{})
+
+	go sleep(context.Background(), conn, "10s")
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("err is %v", err)
+	}
+}
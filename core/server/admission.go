@@ -0,0 +1,139 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	default503QueueBody = []byte("503 server is busy")
+
+	ErrQueueFull    = errors.New("admission queue is full")
+	ErrQueueTimeout = errors.New("admission queue timeout")
+)
+
+type AdmissionConfig struct {
+	// 同时处理的最大请求数 为0时不限制
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// 超过 MaxConcurrent 时 最多有多少请求排队等待 为0时不排队 直接返回503
+	MaxQueue int `yaml:"maxQueue"`
+	// 请求排队的最长时间 单位ms 为0时一直等待 直到客户端断开
+	QueueTimeout int64 `yaml:"queueTimeout"`
+	// 排队的请求超过 MaxQueue 的一半时 认为已经过载 改为后进先出
+	// 过载时先到的请求大概率已经超时 优先处理新的请求 可以减少无效的处理
+	LIFO bool `yaml:"lifo"`
+	// 返回503时 Retry-After 的值 单位s 为0时为1s
+	RetryAfter int64 `yaml:"retryAfter"`
+}
+
+type waiter struct {
+	ready chan struct{} // 获得执行权时关闭
+}
+
+// admission 准入控制 限制同时处理的请求数 超出的请求排队等待
+// 排队的请求不会切换协程 获得执行权后仍然在 ServeHTTP 的协程中处理
+type admission struct {
+	conf       *AdmissionConfig
+	retryAfter string
+
+	mu      sync.Mutex
+	active  int
+	waiters list.List // *waiter 先进入的在前面
+}
+
+func newAdmission(conf *AdmissionConfig) *admission {
+	if conf == nil || conf.MaxConcurrent <= 0 {
+		return nil
+	}
+	retryAfter := conf.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 1
+	}
+	return &admission{
+		conf:       conf,
+		retryAfter: strconv.FormatInt(retryAfter, 10),
+	}
+}
+
+// acquire 获取执行权 返回nil时 处理完成后必须调用 release
+func (a *admission) acquire(ctx context.Context) error {
+	a.mu.Lock()
+	if a.active < a.conf.MaxConcurrent && a.waiters.Len() == 0 {
+		a.active++
+		a.mu.Unlock()
+		return nil
+	}
+	if a.waiters.Len() >= a.conf.MaxQueue {
+		a.mu.Unlock()
+		return ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{})}
+	elem := a.waiters.PushBack(w)
+	a.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if a.conf.QueueTimeout > 0 {
+		timer := time.NewTimer(time.Duration(a.conf.QueueTimeout) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	err := ErrQueueTimeout
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-w.ready:
+		// 超时的同时获得了执行权 这里需要交给下一个请求
+		a.releaseLocked()
+	default:
+		a.waiters.Remove(elem)
+	}
+	return err
+}
+
+func (a *admission) release() {
+	a.mu.Lock()
+	a.releaseLocked()
+	a.mu.Unlock()
+}
+
+// 有请求在排队时 直接将执行权交给排队的请求 active 不变
+func (a *admission) releaseLocked() {
+	if a.waiters.Len() == 0 {
+		a.active--
+		return
+	}
+	elem := a.waiters.Front()
+	if a.conf.LIFO && a.waiters.Len()*2 > a.conf.MaxQueue {
+		elem = a.waiters.Back()
+	}
+	a.waiters.Remove(elem)
+	close(elem.Value.(*waiter).ready)
+}
+
+// 当前正在处理 以及 正在排队的请求数
+func (a *admission) stat() (active int, queued int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active, a.waiters.Len()
+}
+
+func (a *admission) reject(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", a.retryAfter)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(default503QueueBody)
+}
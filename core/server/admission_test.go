@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitQueued(t *testing.T, a *admission, n int) {
+	for i := 0; i < 100; i++ {
+		if _, queued := a.stat(); queued == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("queued is not %d", n)
+}
+
+func TestAdmission(t *testing.T) {
+	e := newTestEngine(&EngConfig{Admission: &AdmissionConfig{
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		RetryAfter:    3,
+	}})
+	block := make(chan struct{})
+	e.GET("/block", func(c *Context) {
+		<-block
+		c.Byte(200, "text/plain", []byte("ok"))
+	})
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = doRequest(e, "GET", "/block").Code
+		}(i)
+		if i == 0 {
+			for active, _ := e.admit.stat(); active != 1; active, _ = e.admit.stat() {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	waitQueued(t, e.admit, 1)
+
+	w := doRequest(e, "GET", "/block")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "3" {
+		t.Fatalf("queue full code is %d , Retry-After is %s", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(block)
+	wg.Wait()
+	if codes[0] != 200 || codes[1] != 200 {
+		t.Fatalf("codes is %v", codes)
+	}
+	if active, queued := e.admit.stat(); active != 0 || queued != 0 {
+		t.Fatalf("active is %d , queued is %d", active, queued)
+	}
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	a := newAdmission(&AdmissionConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: 20})
+	if err := a.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.acquire(context.Background()); err != ErrQueueTimeout {
+		t.Fatalf("err is %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.acquire(ctx); err != context.Canceled {
+		t.Fatalf("err is %v", err)
+	}
+
+	a.release()
+	if active, queued := a.stat(); active != 0 || queued != 0 {
+		t.Fatalf("active is %d , queued is %d", active, queued)
+	}
+}
+
+func TestAdmissionLIFO(t *testing.T) {
+	for _, lifo := range []bool{false, true} {
+		a := newAdmission(&AdmissionConfig{MaxConcurrent: 1, MaxQueue: 4, LIFO: lifo})
+		a.acquire(context.Background())
+
+		order := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func(i int) {
+				a.acquire(context.Background())
+				order <- i
+			}(i)
+			waitQueued(t, a, i+1)
+		}
+
+		a.release()
+		first := <-order
+		// 排队的请求超过 MaxQueue 的一半 LIFO 时最后一个先执行
+		if (lifo && first != 2) || (!lifo && first != 0) {
+			t.Fatalf("lifo is %t , first is %d", lifo, first)
+		}
+	}
+}
@@ -11,7 +11,7 @@ type Binding interface {
 const (
 	MEINJSON          = "application/json"
 	MEINTEXT          = "text/plain"
-	MEINPOSTFORM      = "application/x-www-urlencoded"
+	MEINPOSTFORM      = "application/x-www-form-urlencoded"
 	METIMULTIPARTFORM = "multipart/form-data"
 )
 
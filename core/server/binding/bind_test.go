@@ -2,6 +2,8 @@ package binding
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 	"conan/log"
 )
@@ -75,3 +77,14 @@ func TestBindSlice(t *testing.T) {
 		fmt.Println(v)
 	}
 }
+
+func TestPostFormBind(t *testing.T) {
+	// Content-Type 为 MEINPOSTFORM 时 net/http 才会解析 body 中的 form
+	r, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader("a_field=a"))
+	r.Header.Set("Content-Type", MEINPOSTFORM)
+
+	ts := TestStruct{}
+	if err := postFormBind.Bind(r, &ts); err != nil || ts.A != "a" {
+		t.Fatalf("ts is %v , err is %v", ts, err)
+	}
+}
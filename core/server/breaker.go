@@ -0,0 +1,26 @@
+package server
+
+import (
+	"conan/breaker"
+	"conan/log"
+)
+
+// RegisterBreakerGroup 注册熔断器 注册之后可以通过 /allBreaker 查看所有熔断器的状态
+func (e *Engine) RegisterBreakerGroup(groups ...*breaker.Group) {
+	e.breakerMu.Lock()
+	e.breakers = append(e.breakers, groups...)
+	e.breakerMu.Unlock()
+}
+
+func (e *Engine) allBreakers(c *Context) {
+	e.breakerMu.RLock()
+	stats := make([]breaker.Stat, 0)
+	for _, g := range e.breakers {
+		stats = append(stats, g.Stats()...)
+	}
+	e.breakerMu.RUnlock()
+
+	if err := c.Json(stats, nil); err != nil {
+		log.Error("all Breakers Fail err is %s", err.Error())
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"conan/breaker"
+	"strings"
+	"testing"
+)
+
+func TestAllBreaker(t *testing.T) {
+	e := newTestEngine(nil)
+	g := breaker.NewGroup(&breaker.Config{Type: breaker.TypeHystrix, ConsecutiveFailures: 1})
+	e.RegisterBreakerGroup(g)
+	g.Get("redis")
+	g.Get("mysql").Allow()
+	g.Get("mysql").MakeFailed()
+
+	w := doRequest(e, "GET", "/allBreaker")
+	body := w.Body.String()
+	if w.Code != 200 || !strings.Contains(body, `"name":"mysql","type":"hystrix","state":"open"`) ||
+		!strings.Contains(body, `"name":"redis","type":"hystrix","state":"closed"`) {
+		t.Fatalf("code is %d , body is %s", w.Code, body)
+	}
+}
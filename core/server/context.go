@@ -4,10 +4,13 @@ import (
 	"conan/core/server/binding"
 	"conan/core/server/rending"
 	"context"
-	"fmt"
 	"math"
+	"net"
 	"net/http"
-	"runtime"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 	//"conan/ecode"
 	"conan/log"
 )
@@ -20,55 +23,317 @@ type Chain func(c *Context)
 
 type Context struct {
 	Req *http.Request
-	Res http.ResponseWriter
+	Res ResponseWriter
 	Ctx context.Context
 
-	e       *Engine
-	index   int8
-	handler []HandlerFunc
+	e         *Engine
+	index     int8
+	handler   []HandlerFunc
+	writermem responseWriter // Res 默认指向这里 避免每次请求都分配
 
 	Method     string
 	RouterPath string
+	Params     Params // 路由中 :name 以及 *name 匹配到的参数
+
+	mu         sync.RWMutex
+	Keys       map[string]interface{} // 本次请求中 中间件和handler之间传递数据 通过 Set Get 访问
+	queryCache url.Values
 	//Err        ecode.ErrMsgs
 }
 
-func (c *Context) Abort() {
-	c.index = ABORT_INDEX
+// Context 会被复用 这里把上一次请求的数据清空
+func (c *Context) reset() {
+	c.Res = &c.writermem
+	c.Ctx = nil
+	c.index = -1
+	c.handler = nil
+	c.Method = ""
+	c.RouterPath = ""
+	c.Params = c.Params[:0]
+	c.Keys = nil
+	c.queryCache = nil
 }
 
-func (c *Context) Status(code int) {
-	c.Res.WriteHeader(code)
+// Copy 返回一个可以在 handler 返回之后继续使用的 Context
+// 由于 Context 会被复用 在其他协程中使用时 必须使用 Copy 之后的 Context
+func (c *Context) Copy() *Context {
+	cp := &Context{
+		Req:        c.Req,
+		Ctx:        c.Ctx,
+		e:          c.e,
+		index:      ABORT_INDEX,
+		writermem:  c.writermem,
+		Method:     c.Method,
+		RouterPath: c.RouterPath,
+	}
+	// 复制出来的 Context 不能再写入响应 写入的内容会被丢弃
+	cp.writermem.ResponseWriter = &discardWriter{header: make(http.Header)}
+	cp.Res = &cp.writermem
+	cp.Params = make(Params, len(c.Params))
+	copy(cp.Params, c.Params)
+
+	c.mu.RLock()
+	if c.Keys != nil {
+		cp.Keys = make(map[string]interface{}, len(c.Keys))
+		for k, v := range c.Keys {
+			cp.Keys[k] = v
+		}
+	}
+	c.mu.RUnlock()
+	return cp
 }
 
-func (c *Context) Next() {
+// Set 保存本次请求的数据 可以在后续的handler中通过 Get 获取
+func (c *Context) Set(key string, value interface{}) {
+	c.mu.Lock()
+	if c.Keys == nil {
+		c.Keys = make(map[string]interface{})
+	}
+	c.Keys[key] = value
+	c.mu.Unlock()
+}
+
+func (c *Context) Get(key string) (value interface{}, exists bool) {
+	c.mu.RLock()
+	value, exists = c.Keys[key]
+	c.mu.RUnlock()
+	return
+}
 
-	defer func() {
+// MustGet key 不存在时会panic
+func (c *Context) MustGet(key string) interface{} {
+	if value, exists := c.Get(key); exists {
+		return value
+	}
+	panic("Key \"" + key + "\" does not exist")
+}
 
-		if err := recover(); err != nil {
-			runtime.Caller(1)
-			buf := make([]byte, 4086)
-			n := runtime.Stack(buf, false)
-			pl := fmt.Sprintf("http server panic: %v\n%s\n", err, buf[:n])
-			log.Error("http server recover  is %s", pl)
-			c.Byte(500, "text/plain", default505Body)
+func (c *Context) GetString(key string) (s string) {
+	if val, ok := c.Get(key); ok && val != nil {
+		s, _ = val.(string)
+	}
+	return
+}
+
+func (c *Context) GetInt(key string) (i int) {
+	if val, ok := c.Get(key); ok && val != nil {
+		i, _ = val.(int)
+	}
+	return
+}
+
+func (c *Context) GetInt64(key string) (i int64) {
+	if val, ok := c.Get(key); ok && val != nil {
+		i, _ = val.(int64)
+	}
+	return
+}
+
+func (c *Context) GetBool(key string) (b bool) {
+	if val, ok := c.Get(key); ok && val != nil {
+		b, _ = val.(bool)
+	}
+	return
+}
+
+// ================== 请求参数 ========================
+
+func (c *Context) initQueryCache() {
+	if c.queryCache == nil {
+		c.queryCache = c.Req.URL.Query()
+	}
+}
+
+// Query 返回url中的参数 eg: /user?id=1 c.Query("id") == "1"
+func (c *Context) Query(key string) string {
+	value, _ := c.GetQuery(key)
+	return value
+}
+
+// DefaultQuery 参数不存在时返回 defaultValue
+func (c *Context) DefaultQuery(key string, defaultValue string) string {
+	if value, ok := c.GetQuery(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (c *Context) GetQuery(key string) (string, bool) {
+	c.initQueryCache()
+	if values, ok := c.queryCache[key]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+// PostForm 返回 body 中 urlencoded 或者 multipart 形式的参数
+func (c *Context) PostForm(key string) string {
+	value, _ := c.GetPostForm(key)
+	return value
+}
+
+func (c *Context) DefaultPostForm(key string, defaultValue string) string {
+	if value, ok := c.GetPostForm(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
+func (c *Context) GetPostForm(key string) (string, bool) {
+	if err := c.ParseForm(); err != nil {
+		log.Error("Context: Parse Form Err is %s ", err.Error())
+	}
+	if values, ok := c.Req.PostForm[key]; ok && len(values) > 0 {
+		return values[0], true
+	}
+	if c.Req.MultipartForm != nil {
+		if values, ok := c.Req.MultipartForm.Value[key]; ok && len(values) > 0 {
+			return values[0], true
 		}
+	}
+	return "", false
+}
 
-	}()
+func (c *Context) GetHeader(key string) string {
+	return c.Req.Header.Get(key)
+}
 
+// Cookie 返回解码后的cookie 不存在时返回 http.ErrNoCookie
+func (c *Context) Cookie(name string) (string, error) {
+	cookie, err := c.Req.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return url.QueryUnescape(cookie.Value)
+}
+
+// SetCookie cookie.Value 会被编码 通过 Cookie 获取时会自动解码
+// 编码以及设置默认的 Path 都在副本上进行 不会修改传入的 cookie
+func (c *Context) SetCookie(cookie *http.Cookie) {
+	cp := *cookie
+	if cp.Path == "" {
+		cp.Path = "/"
+	}
+	cp.Value = url.QueryEscape(cp.Value)
+	http.SetCookie(c.Res, &cp)
+}
+
+// ClientIP 返回客户端的ip 只有当请求来自 EngConfig.TrustedProxies 中的代理时
+// 才会使用 X-Forwarded-For 以及 X-Real-Ip 中的值
+func (c *Context) ClientIP() string {
+	remoteIP, _, err := net.SplitHostPort(strings.TrimSpace(c.Req.RemoteAddr))
+	if err != nil {
+		remoteIP = strings.TrimSpace(c.Req.RemoteAddr)
+	}
+
+	if c.e == nil || !c.e.isTrustedProxy(net.ParseIP(remoteIP)) {
+		return remoteIP
+	}
+
+	// X-Forwarded-For: client, proxy1, proxy2 从右往左找到第一个不是可信代理的ip
+	if forwarded := c.Req.Header.Get("X-Forwarded-For"); forwarded != "" {
+		items := strings.Split(forwarded, ",")
+		for i := len(items) - 1; i >= 0; i-- {
+			ipStr := strings.TrimSpace(items[i])
+			ip := net.ParseIP(ipStr)
+			if ip == nil {
+				break
+			}
+			if i == 0 || !c.e.isTrustedProxy(ip) {
+				return ipStr
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(c.Req.Header.Get("X-Real-Ip")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return remoteIP
+}
+
+// Context 实现了 context.Context 可以直接传给 redis sqldb 等需要 context 的地方
+// 这里都是代理到 c.Ctx 上 在handler返回之后使用时 需要先调用 c.Copy()
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	if c.Ctx == nil {
+		return
+	}
+	return c.Ctx.Deadline()
+}
+
+func (c *Context) Done() <-chan struct{} {
+	if c.Ctx == nil {
+		return nil
+	}
+	return c.Ctx.Done()
+}
+
+func (c *Context) Err() error {
+	if c.Ctx == nil {
+		return nil
+	}
+	return c.Ctx.Err()
+}
+
+// Value key 为string时 先从 c.Keys 中查找
+func (c *Context) Value(key interface{}) interface{} {
+	if keyStr, ok := key.(string); ok {
+		if val, exists := c.Get(keyStr); exists {
+			return val
+		}
+	}
+	if c.Ctx == nil {
+		return nil
+	}
+	return c.Ctx.Value(key)
+}
+
+// ParseForm 根据 Content-Type 解析form 多次调用只会解析一次
+func (c *Context) ParseForm() error {
+	if c.Req.Form != nil {
+		return nil
+	}
+	if strings.Contains(c.Req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := c.Req.ParseMultipartForm(MAX_MEM); err != nil && err != http.ErrNotMultipart {
+			return err
+		}
+		return nil
+	}
+	return c.Req.ParseForm()
+}
+
+func (c *Context) Abort() {
+	c.index = ABORT_INDEX
+}
+
+// Param 返回路由参数的值 eg: 注册 /users/:id 请求 /users/1 时 c.Param("id") 为 "1"
+func (c *Context) Param(key string) string {
+	return c.Params.ByName(key)
+}
+
+func (c *Context) Status(code int) {
+	c.Res.WriteHeader(code)
+}
+
+// Next 执行后续的handler 中间件中调用 Next 可以在后续handler执行完成后 再做处理
+// panic 不在这里recover 否则中间件无法捕获到后续handler的panic
+func (c *Context) Next() {
 	c.index++
-	// 计算时间
 	for c.handler != nil && c.index < int8(len(c.handler)) {
 		c.handler[c.index](c)
 		c.index++
 	}
-	// 采集rtt
 }
 
 func (c *Context) Render(code int, render rending.Render) error {
-	render.WriteContentType(c.Res)
+	// 这里只是记录状态码 真正写入是在 render.Render 第一次Write的时候
+	// 所以 Content-Type 的设置不会被忽略
+	c.Status(code)
 
-	if code != http.StatusOK {
-		c.Status(code)
+	if !bodyAllowedForStatus(code) {
+		render.WriteContentType(c.Res)
+		c.Res.WriteHeaderNow()
+		c.Abort()
+		return nil
 	}
 	//if c.Err != nil {
 	//
@@ -85,6 +350,19 @@ func (c *Context) Render(code int, render rending.Render) error {
 	return nil
 }
 
+// 1xx 204 304 的响应不允许有body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent:
+		return false
+	case status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
 func (c *Context) Json(data interface{}, err error) error {
 	code := http.StatusOK
 
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestContextKeys(t *testing.T) {
+	e := newTestEngine(&EngConfig{})
+	e.UseFunc(func(c *Context) {
+		c.Set("user", "conan")
+		c.Set("uid", int64(10))
+		c.Next()
+	})
+	e.GET("/keys", func(c *Context) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Set("admin", true)
+				c.GetString("user")
+			}()
+		}
+		wg.Wait()
+
+		if c.GetString("user") != "conan" || c.GetInt64("uid") != 10 || !c.GetBool("admin") {
+			t.Fatalf("keys is %v", c.Keys)
+		}
+		if c.Value("user") != "conan" || c.MustGet("uid") != int64(10) {
+			t.Fatalf("keys is %v", c.Keys)
+		}
+		if c.GetInt("user") != 0 || c.GetString("nothing") != "" {
+			t.Fatalf("typed getter should return zero value")
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	w := doRequest(e, "GET", "/keys")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("code is %d", w.Code)
+	}
+}
+
+func TestContextCopy(t *testing.T) {
+	e := newTestEngine(&EngConfig{})
+	e.GET("/copy/:id", func(c *Context) {
+		c.Set("user", "conan")
+		cp := c.Copy()
+		if cp.Params.ByName("id") != "10" || cp.GetString("user") != "conan" {
+			t.Fatalf("params is %v , keys is %v", cp.Params, cp.Keys)
+		}
+		// 复制出来的 Context 写入响应时不会 panic 也不会影响原来的响应
+		if err := cp.Byte(http.StatusInternalServerError, "text/plain", []byte("copy")); err != nil {
+			t.Fatal(err)
+		}
+		c.Byte(http.StatusOK, "text/plain", []byte("origin"))
+	})
+
+	w := doRequest(e, "GET", "/copy/10")
+	if w.Code != http.StatusOK || w.Body.String() != "origin" {
+		t.Fatalf("code is %d , body is %s", w.Code, w.Body.String())
+	}
+}
+
+func TestContextInput(t *testing.T) {
+	e := newTestEngine(&EngConfig{})
+	e.POST("/input", func(c *Context) {
+		if c.Query("a") != "1" || c.DefaultQuery("b", "2") != "2" {
+			t.Fatalf("query is %s", c.Req.URL.RawQuery)
+		}
+		if c.PostForm("name") != "conan" || c.DefaultPostForm("age", "18") != "18" {
+			t.Fatalf("post form is %v", c.Req.PostForm)
+		}
+		if c.GetHeader("X-Test") != "test" {
+			t.Fatalf("header is %v", c.Req.Header)
+		}
+		if v, err := c.Cookie("token"); err != nil || v != "a b" {
+			t.Fatalf("cookie is %s , err is %v", v, err)
+		}
+		cookie := &http.Cookie{Name: "session", Value: "x y"}
+		c.SetCookie(cookie)
+		if cookie.Value != "x y" || cookie.Path != "" {
+			t.Fatalf("cookie is modified %+v", cookie)
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	form := url.Values{"name": {"conan"}}
+	r := httptest.NewRequest("POST", "/input?a=1", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Test", "test")
+	r.AddCookie(&http.Cookie{Name: "token", Value: url.QueryEscape("a b")})
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent || !strings.Contains(w.Header().Get("Set-Cookie"), "session=x+y") {
+		t.Fatalf("code is %d , Set-Cookie is %s", w.Code, w.Header().Get("Set-Cookie"))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	e := newTestEngine(&EngConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+
+	var ip string
+	e.GET("/ip", func(c *Context) {
+		ip = c.ClientIP()
+	})
+
+	cases := []struct {
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"1.1.1.1:80", "2.2.2.2", "", "1.1.1.1"},
+		{"10.0.0.1:80", "2.2.2.2, 10.0.0.2", "", "2.2.2.2"},
+		{"192.168.1.1:80", "3.3.3.3, 2.2.2.2, 10.0.0.2", "", "2.2.2.2"},
+		{"10.0.0.1:80", "", "4.4.4.4", "4.4.4.4"},
+		{"10.0.0.1:80", "", "", "10.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/ip", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.realIP != "" {
+			r.Header.Set("X-Real-Ip", c.realIP)
+		}
+		e.ServeHTTP(httptest.NewRecorder(), r)
+		if ip != c.want {
+			t.Fatalf("remote %s forwarded %s ip is %s , want %s", c.remote, c.forwarded, ip, c.want)
+		}
+	}
+}
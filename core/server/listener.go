@@ -0,0 +1,363 @@
+package server
+
+import (
+	"conan/log"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// systemd socket activation 传递的 fd 从3开始
+	listenFdsStart = 3
+
+	NetworkTCP  = "tcp"
+	NetworkUnix = "unix"
+	NetworkFd   = "fd"
+
+	// 等待新连接读取到请求的最长时间 和 http.Server.Shutdown 中 StateNew 连接的处理一致
+	// 否则只建立连接不发送请求的客户端 会让关闭一直阻塞
+	maxNewConnWait = 5 * time.Second
+)
+
+var (
+	ErrListenerExist    = errors.New("listener is already exist")
+	ErrListenerNotFound = errors.New("listener is not found")
+)
+
+type ListenerConfig struct {
+	// 监听的名字 用于单独启停 为空时为 network://addr
+	Name string `yaml:"name"`
+	// tcp tcp4 tcp6 unix fd 默认为tcp
+	Network string `yaml:"network"`
+	// network 为 unix 时为 socket 文件的路径
+	// network 为 fd 时为 LISTEN_FDNAMES 中的名字 或者是fd的序号(从0开始)
+	Addr string `yaml:"addr"`
+	// unix socket 文件的权限 eg: 0660 为0时不修改
+	FileMode uint32 `yaml:"fileMode"`
+}
+
+func (l *ListenerConfig) name() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.network() + "://" + l.Addr
+}
+
+func (l *ListenerConfig) network() string {
+	if l.Network == "" {
+		return NetworkTCP
+	}
+	return l.Network
+}
+
+// listener 每个监听有自己的 http.Server 这样可以单独关闭
+type listener struct {
+	conf   *ListenerConfig
+	ln     net.Listener
+	served *onceCloseListener // 交给 http.Server 的 ln 可以提前关闭
+	ser    *http.Server
+
+	mu       sync.Mutex
+	newConns map[net.Conn]struct{} // 已经 Accept 但是还没有读取到请求的连接
+}
+
+// http.Server.Shutdown 时会再次关闭监听 这里保证只关闭一次
+type onceCloseListener struct {
+	net.Listener
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		l.err = l.Listener.Close()
+	})
+	return l.err
+}
+
+func (l *listener) connState(c net.Conn, state http.ConnState) {
+	l.mu.Lock()
+	if state == http.StateNew {
+		l.newConns[c] = struct{}{}
+	} else {
+		delete(l.newConns, c)
+	}
+	l.mu.Unlock()
+}
+
+// 等待已经 Accept 的连接读取到第一个请求 最多等待 maxNewConnWait
+// http.Server.Shutdown 之后 这些连接读取到请求时会被直接关闭 客户端会收到 EOF
+func (l *listener) waitNewConns(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timer := time.NewTimer(maxNewConnWait)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		n := len(l.newConns)
+		l.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listen 根据配置创建 net.Listener
+// 优先使用继承的同名fd 平滑升级时 新进程通过这种方式接管旧进程的监听
+func listen(conf *ListenerConfig) (net.Listener, error) {
+	if conf.network() == NetworkFd {
+		return inheritedListener(conf.Addr)
+	}
+	if ln, err := inheritedListener(conf.name()); err == nil {
+		return ln, nil
+	}
+
+	if conf.network() == NetworkUnix {
+		return listenUnix(conf)
+	}
+	return net.Listen(conf.network(), conf.Addr)
+}
+
+func listenUnix(conf *ListenerConfig) (net.Listener, error) {
+	// 上次进程异常退出时 socket 文件不会被删除 这里需要先删掉 否则会 address already in use
+	if fi, err := os.Stat(conf.Addr); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("unix socket %s is not a socket file", conf.Addr)
+		}
+		if err := os.Remove(conf.Addr); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen(NetworkUnix, conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	// 关闭时 net.UnixListener 会删除 socket 文件
+	if conf.FileMode != 0 {
+		if err := os.Chmod(conf.Addr, os.FileMode(conf.FileMode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+var (
+	inheritedOnce  sync.Once
+	inheritedMu    sync.Mutex
+	inheritedFiles map[string]*os.File // 名字以及序号 -> fd
+)
+
+// 解析 systemd 传递的 LISTEN_PID LISTEN_FDS LISTEN_FDNAMES
+// 平滑升级时 父进程无法提前知道子进程的pid 这里通过 CONAN_UPGRADE_PPID 校验父进程
+// 解析完成后会删除这些环境变量 避免被子进程继承
+func loadInheritedFiles() {
+	inheritedFiles = make(map[string]*os.File)
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(envUpgradePPid)
+		os.Unsetenv(envUpgradeReadyFd)
+	}()
+
+	if !inheritedFromParent() {
+		pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+		if err != nil || pid != os.Getpid() {
+			return
+		}
+	}
+	loadUpgradeReadyFile()
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+
+	var names []string
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		name := strconv.Itoa(i)
+		f := os.NewFile(uintptr(fd), "listen_fd_"+name)
+		inheritedFiles[name] = f
+		if i < len(names) && names[i] != "" {
+			if n, err := url.QueryUnescape(names[i]); err == nil {
+				inheritedFiles[n] = f
+			} else {
+				inheritedFiles[names[i]] = f
+			}
+		}
+	}
+}
+
+// inheritedListener 使用继承的fd 创建 net.Listener 每个fd只能使用一次
+func inheritedListener(name string) (net.Listener, error) {
+	inheritedOnce.Do(loadInheritedFiles)
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+
+	f, ok := inheritedFiles[name]
+	if !ok {
+		return nil, fmt.Errorf("inherited listener %s is not found", name)
+	}
+	for k, v := range inheritedFiles {
+		if v == f {
+			delete(inheritedFiles, k)
+		}
+	}
+
+	// FileListener 会 dup 一个新的fd 这里原来的需要关闭
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// 配置中的所有监听 Network Addr 为默认的监听
+// 只配置了 Listeners 并且 Addr 为空时 不会创建默认监听
+func (e *Engine) listenerConfigs() []*ListenerConfig {
+	confs := make([]*ListenerConfig, 0, len(e.cfg.Listeners)+1)
+	if e.cfg.Addr != "" || len(e.cfg.Listeners) == 0 {
+		confs = append(confs, &ListenerConfig{
+			Network: e.cfg.Network,
+			Addr:    e.cfg.Addr,
+		})
+	}
+	return append(confs, e.cfg.Listeners...)
+}
+
+func (e *Engine) newHTTPServer() *http.Server {
+	ser := &http.Server{
+		Handler:      e.httpHandler,
+		IdleTimeout:  time.Duration(e.cfg.IdleTimeout) * time.Second,
+		ReadTimeout:  time.Duration(e.cfg.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(e.cfg.WriteTimeout) * time.Second,
+	}
+	if e.tlsConf != nil {
+		ser.TLSConfig = e.tlsConf.Clone()
+	}
+	return ser
+}
+
+// startListener 创建监听 并启动一个协程处理该监听上的请求
+func (e *Engine) startListener(conf *ListenerConfig) error {
+	name := conf.name()
+
+	e.lnMu.Lock()
+	defer e.lnMu.Unlock()
+
+	if _, ok := e.listeners[name]; ok {
+		return ErrListenerExist
+	}
+
+	ln, err := listen(conf)
+	if err != nil {
+		log.Error("Http Server Listen Fail name is %s , err is %s", name, err.Error())
+		return err
+	}
+
+	l := &listener{
+		conf:     conf,
+		ln:       ln,
+		served:   &onceCloseListener{Listener: ln},
+		ser:      e.newHTTPServer(),
+		newConns: make(map[net.Conn]struct{}),
+	}
+	l.ser.ConnState = l.connState
+	e.listeners[name] = l
+
+	// Serve 时 net/http 会修改 TLSConfig 需要在启动协程之前读取
+	isTLS := l.ser.TLSConfig != nil
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		var err error
+		if isTLS {
+			// ServeTLS 时会自动在 NextProtos 中加入 h2
+			err = l.ser.ServeTLS(l.served, "", "")
+		} else {
+			err = l.ser.Serve(l.served)
+		}
+		if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+			log.Error("Http Server Serve Err name is %s , err is %s", name, err.Error())
+		}
+	}()
+	log.Info("Http Server Listen name is %s , addr is %s , tls is %t", name, ln.Addr().String(), isTLS)
+	return nil
+}
+
+// stopListener 关闭监听 并等待该监听上正在处理的请求完成 超时后强制关闭连接
+func (e *Engine) stopListener(ctx context.Context, l *listener) error {
+	name := l.conf.name()
+	// 先停止 Accept 等待已经建立的连接读到请求之后 再 Shutdown
+	l.served.Close()
+	l.waitNewConns(ctx)
+	if err := l.ser.Shutdown(ctx); err != nil {
+		l.ser.Close()
+		log.Error("Http Server Shutdown Fail name is %s , err is %s", name, err.Error())
+		return err
+	}
+	log.Info("Http Server Stop name is %s", name)
+	return nil
+}
+
+// AddListener 增加一个监听 服务已经启动时会立即开始监听 否则在 RunServer 时启动
+func (e *Engine) AddListener(conf *ListenerConfig) error {
+	if e.isClosed() {
+		e.lnMu.Lock()
+		defer e.lnMu.Unlock()
+		for _, c := range e.pending {
+			if c.name() == conf.name() {
+				return ErrListenerExist
+			}
+		}
+		e.pending = append(e.pending, conf)
+		return nil
+	}
+	return e.startListener(conf)
+}
+
+// RemoveListener 关闭名字为 name 的监听 其他监听不受影响
+func (e *Engine) RemoveListener(ctx context.Context, name string) error {
+	e.lnMu.Lock()
+	l, ok := e.listeners[name]
+	delete(e.listeners, name)
+	e.lnMu.Unlock()
+
+	if !ok {
+		return ErrListenerNotFound
+	}
+	return e.stopListener(ctx, l)
+}
+
+// Addrs 返回当前所有监听的地址 key 为监听的名字
+func (e *Engine) Addrs() map[string]net.Addr {
+	e.lnMu.Lock()
+	defer e.lnMu.Unlock()
+
+	addrs := make(map[string]net.Addr, len(e.listeners))
+	for name, l := range e.listeners {
+		addrs[name] = l.ln.Addr()
+	}
+	return addrs
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func unixClient(sock string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, NetworkUnix, sock)
+		},
+	}}
+}
+
+func getBody(t *testing.T, cli *http.Client, url string) string {
+	resp, err := cli.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestMultiListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conan_unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "conan.sock")
+
+	addr, admin := freeAddr(t), freeAddr(t)
+	e := NewEngine(&EngConfig{
+		Addr:            addr,
+		ShutdownTimeout: 3,
+		Listeners: []*ListenerConfig{
+			{Name: "admin", Addr: admin},
+			{Name: "unix", Network: NetworkUnix, Addr: sock, FileMode: 0600},
+		},
+	})
+	e.GET("/ping", func(c *Context) {
+		c.Byte(200, "text/plain", []byte("pong"))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(context.Background())
+
+	if len(e.Addrs()) != 3 {
+		t.Fatalf("addrs is %v", e.Addrs())
+	}
+	fi, err := os.Stat(sock)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("unix socket stat is %v , err is %v", fi, err)
+	}
+
+	for _, u := range []string{"http://" + addr, "http://" + admin} {
+		if b := getBody(t, http.DefaultClient, u+"/ping"); b != "pong" {
+			t.Fatalf("%s body is %s", u, b)
+		}
+	}
+	if b := getBody(t, unixClient(sock), "http://unix/ping"); b != "pong" {
+		t.Fatalf("unix body is %s", b)
+	}
+
+	if err := e.RemoveListener(context.Background(), "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveListener(context.Background(), "admin"); err != ErrListenerNotFound {
+		t.Fatalf("remove again err is %v", err)
+	}
+	if _, err := net.Dial("tcp", admin); err == nil {
+		t.Fatal("admin listener should be closed")
+	}
+	if b := getBody(t, http.DefaultClient, "http://"+addr+"/ping"); b != "pong" {
+		t.Fatalf("body is %s", b)
+	}
+
+	if err := e.AddListener(&ListenerConfig{Name: "admin", Addr: admin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddListener(&ListenerConfig{Name: "admin", Addr: admin}); err != ErrListenerExist {
+		t.Fatalf("add again err is %v", err)
+	}
+	if b := getBody(t, http.DefaultClient, "http://"+admin+"/ping"); b != "pong" {
+		t.Fatalf("admin body is %s", b)
+	}
+
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("unix socket should be removed err is %v", err)
+	}
+}
+
+func TestShutdownIdleNewConn(t *testing.T) {
+	addr := freeAddr(t)
+	e := NewEngine(&EngConfig{Addr: addr})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只建立连接 不发送请求
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		e.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(maxNewConnWait + 3*time.Second):
+		t.Fatal("Close is blocked by the idle new conn")
+	}
+}
+
+// 子进程中执行 使用父进程传递的fd 提供服务
+func TestInheritedListenerHelper(t *testing.T) {
+	if os.Getenv("CONAN_TEST_LISTEN_FDS") != "1" {
+		t.Skip("only run in child process")
+	}
+	// 父进程在 fork 前无法知道子进程的pid 这里由子进程自己设置
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	e := NewEngine(&EngConfig{
+		Listeners: []*ListenerConfig{{Network: NetworkFd, Addr: "web"}},
+	})
+	e.GET("/pid", func(c *Context) {
+		c.Byte(200, "text/plain", []byte(strconv.Itoa(os.Getpid())))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Second)
+	e.Close()
+}
+
+func TestInheritedListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInheritedListenerHelper$")
+	cmd.Env = append(os.Environ(), "CONAN_TEST_LISTEN_FDS=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=web")
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	// 父进程不会 Accept 连接都会由子进程处理
+	b := getBody(t, &http.Client{Timeout: 5 * time.Second}, "http://"+ln.Addr().String()+"/pid")
+	if b != strconv.Itoa(cmd.Process.Pid) {
+		t.Fatalf("pid is %s , child is %d", b, cmd.Process.Pid)
+	}
+}
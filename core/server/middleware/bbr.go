@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"conan/bbr"
+	"conan/core/server"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// HeaderPriority 网关等上游设置的请求优先级 low normal high 默认不使用 见 TrustPriorityHeader
+	HeaderPriority = "X-Priority"
+)
+
+type bbrOptions struct {
+	trustHeader bool
+}
+
+type BBROption func(*bbrOptions)
+
+// TrustPriorityHeader 使用请求头 HeaderPriority 中的优先级
+// 任何客户端都可以设置该请求头 只有在网关会覆盖或者删除客户端传来的 HeaderPriority 时才能开启
+// 否则客户端可以通过 high 绕过限流
+func TrustPriorityHeader() BBROption {
+	return func(o *bbrOptions) {
+		o.trustHeader = true
+	}
+}
+
+var (
+	default503Body = []byte("503 server is overload")
+
+	errHandlerPanic = errors.New("handler panic")
+)
+
+// BBR 自适应限流 cpu使用率过高 并且正在处理的请求数超过系统的处理能力时 直接返回503
+// limiter 为空时使用默认配置创建 每个 Engine 或者 RouterGroup 应该使用不同的 limiter
+// 需要调用 bbr.Init 开始采集cpu使用率
+// 请求的优先级优先使用 Priority 设置的 开启 TrustPriorityHeader 时其次使用 HeaderPriority 过载时先丢弃低优先级的请求
+func BBR(limiter bbr.Limiter, opts ...BBROption) server.HandlerFunc {
+	if limiter == nil {
+		limiter = bbr.NewLimiter(nil)
+	}
+	o := &bbrOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(c *server.Context) {
+		if _, ok := bbr.PriorityFromContext(c.Ctx); !ok && o.trustHeader {
+			if h := c.GetHeader(HeaderPriority); h != "" {
+				c.Ctx = bbr.WithPriority(c.Ctx, bbr.ParsePriority(h))
+			}
+		}
+
+		done, err := limiter.Allow(c.Ctx)
+		if err != nil {
+			c.Byte(http.StatusServiceUnavailable, "text/plain; chatset=utf-8", default503Body)
+			c.Abort()
+			return
+		}
+
+		finished := false
+		defer func() {
+			// 后续的handler panic 了 这里不 recover 交给 Recovery 处理
+			if !finished {
+				done(bbr.DoneInfo{Op: bbr.Drop, Err: errHandlerPanic})
+			}
+		}()
+
+		c.Next()
+		finished = true
+		done(doneInfo(c))
+	}
+}
+
+// Priority 设置请求的优先级 需要在 BBR 之前执行 可以针对 RouterGroup 或者单个路由使用
+// 会覆盖 HeaderPriority 中的优先级
+func Priority(p bbr.Priority) server.HandlerFunc {
+	return func(c *server.Context) {
+		c.Ctx = bbr.WithPriority(c.Ctx, p)
+		c.Next()
+	}
+}
+
+// 只有正常处理完成的请求 才会用于计算系统的处理能力
+func doneInfo(c *server.Context) bbr.DoneInfo {
+	switch err := c.Ctx.Err(); err {
+	case context.Canceled:
+		// 客户端主动断开 无法判断服务端的处理情况
+		return bbr.DoneInfo{Op: bbr.Ignore, Err: err}
+	case context.DeadlineExceeded:
+		return bbr.DoneInfo{Op: bbr.Drop, Err: err}
+	}
+
+	if status := c.Res.Status(); status >= http.StatusInternalServerError {
+		return bbr.DoneInfo{Op: bbr.Drop, Err: fmt.Errorf("response status is %d", status)}
+	}
+	return bbr.DoneInfo{Op: bbr.Success}
+}
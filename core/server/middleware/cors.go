@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"conan/core/server"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSConfig struct {
+	AllowOrigins     []string // 允许的 Origin "*" 表示允许所有
+	AllowMethods     []string
+	AllowHeaders     []string // 为空时 预检请求中带过来的 header 都允许
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration // 预检请求结果的缓存时间
+}
+
+var (
+	defaultCORSConf = &CORSConfig{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
+		MaxAge:       12 * time.Hour,
+	}
+)
+
+// CORS 处理跨域请求 预检请求(OPTIONS + Access-Control-Request-Method) 会直接返回 204
+func CORS(conf *CORSConfig) server.HandlerFunc {
+	if conf == nil {
+		conf = defaultCORSConf
+	}
+
+	allowAll := false
+	origins := make(map[string]struct{}, len(conf.AllowOrigins))
+	for _, o := range conf.AllowOrigins {
+		if o == "*" {
+			allowAll = true
+		}
+		origins[strings.ToLower(o)] = struct{}{}
+	}
+
+	allowMethods := strings.Join(conf.AllowMethods, ", ")
+	allowHeaders := strings.Join(conf.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(conf.ExposeHeaders, ", ")
+	maxAge := strconv.FormatInt(int64(conf.MaxAge/time.Second), 10)
+
+	return func(c *server.Context) {
+		origin := c.Req.Header.Get("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Res.Header()
+		header.Add("Vary", "Origin")
+
+		preflight := c.Req.Method == http.MethodOptions && c.Req.Header.Get("Access-Control-Request-Method") != ""
+
+		if _, ok := origins[strings.ToLower(origin)]; !ok && !allowAll {
+			if preflight {
+				c.Status(http.StatusForbidden)
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if allowAll && !conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", allowMethods)
+		if allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+		} else if reqHeaders := c.Req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if conf.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.Status(http.StatusNoContent)
+		c.Abort()
+	}
+}
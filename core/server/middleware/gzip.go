@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"conan/core/server"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type GzipConfig struct {
+	Level int // 压缩等级 默认为 gzip.DefaultCompression
+}
+
+var (
+	defaultGzipConf = &GzipConfig{
+		Level: gzip.DefaultCompression,
+	}
+)
+
+// Gzip 客户端支持gzip时 压缩响应的内容
+func Gzip(conf *GzipConfig) server.HandlerFunc {
+	if conf == nil {
+		conf = defaultGzipConf
+	}
+	if _, err := gzip.NewWriterLevel(nil, conf.Level); err != nil {
+		panic("gzip level is invalid " + err.Error())
+	}
+
+	pool := &sync.Pool{
+		New: func() interface{} {
+			gz, _ := gzip.NewWriterLevel(nil, conf.Level)
+			return gz
+		},
+	}
+
+	return func(c *server.Context) {
+		if !shouldCompress(c.Req) {
+			c.Next()
+			return
+		}
+
+		gz := pool.Get().(*gzip.Writer)
+		gz.Reset(c.Res)
+
+		w := &gzipWriter{ResponseWriter: c.Res, gz: gz}
+		c.Res = w
+		defer func() {
+			w.close()
+			pool.Put(gz)
+		}()
+
+		c.Next()
+	}
+}
+
+func shouldCompress(r *http.Request) bool {
+	if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		return false
+	}
+	if r.Method == http.MethodHead || strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return false
+	}
+	return true
+}
+
+type gzipWriter struct {
+	server.ResponseWriter
+	gz       *gzip.Writer
+	prepared bool
+	compress bool // 是否真正的进行了压缩 没有body 以及已经被编码过的响应 不进行压缩
+}
+
+// 1xx 204 304 不能有body
+func bodyAllowed(code int) bool {
+	return code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// 在header真正写入之前 根据状态码决定是否压缩
+// hasBody 为 false 时 表示header在写入body之前就要发送 这时不压缩 避免空的响应带上 gzip 的头和尾
+func (w *gzipWriter) prepare(hasBody bool) {
+	if w.prepared {
+		return
+	}
+	w.prepared = true
+
+	header := w.Header()
+	if !hasBody || w.Written() || !bodyAllowed(w.Status()) || header.Get("Content-Encoding") != "" {
+		return
+	}
+
+	w.compress = true
+	header.Set("Content-Encoding", "gzip")
+	header.Add("Vary", "Accept-Encoding")
+	header.Del("Content-Length")
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if !w.prepared {
+		// 空的 Write 不决定是否压缩
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.prepare(true)
+	}
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	return w.gz.Write(b)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) WriteHeaderNow() {
+	w.prepare(false)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *gzipWriter) Flush() {
+	w.WriteHeaderNow()
+	if w.compress {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *gzipWriter) close() {
+	if w.compress {
+		w.gz.Close()
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"conan/core/server"
+	"conan/log"
+	"go.uber.org/zap"
+	"time"
+)
+
+type LoggerConfig struct {
+	SkipPaths []string // 不需要记录访问日志的path eg: /debug/pprof/heap
+}
+
+var (
+	defaultLoggerConf = &LoggerConfig{}
+)
+
+// Logger 记录每个请求的访问日志 包括 状态码 耗时 响应大小等
+func Logger(conf *LoggerConfig) server.HandlerFunc {
+	if conf == nil {
+		conf = defaultLoggerConf
+	}
+
+	skip := make(map[string]struct{}, len(conf.SkipPaths))
+	for _, p := range conf.SkipPaths {
+		skip[p] = struct{}{}
+	}
+
+	return func(c *server.Context) {
+		path := c.Req.URL.Path
+		if _, ok := skip[path]; ok {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+
+		c.Next()
+
+		log.InfoFields("access",
+			zap.String("method", c.Req.Method),
+			zap.String("path", path),
+			zap.String("query", c.Req.URL.RawQuery),
+			zap.String("router", c.RouterPath),
+			zap.Int("status", c.Res.Status()),
+			zap.Int("size", c.Res.Size()),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("ip", c.ClientIP()),
+			zap.String("user_agent", c.Req.UserAgent()),
+			zap.String("request_id", GetRequestID(c.Ctx)),
+		)
+	}
+}
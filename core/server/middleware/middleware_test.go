@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"conan/bbr"
+	"conan/core/server"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func startEngine(t *testing.T, middle ...server.HandlerFunc) (*server.Engine, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	e := server.NewEngine(&server.EngConfig{Addr: addr, ShutdownTimeout: 3})
+	e.UseFunc(middle...)
+	e.GET("/hello", func(c *server.Context) {
+		c.Byte(200, "text/plain", []byte("hello world"))
+	})
+	e.GET("/empty", func(c *server.Context) {})
+	e.GET("/panic", func(c *server.Context) {
+		panic("boom")
+	})
+	e.GET("/request_id", func(c *server.Context) {
+		c.Byte(200, "text/plain", []byte(GetRequestID(c.Ctx)))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	return e, "http://" + addr
+}
+
+func TestRecovery(t *testing.T) {
+	called := false
+	e, base := startEngine(t, Logger(nil), Recovery(&RecoveryConfig{
+		Handler: func(c *server.Context, err interface{}) {
+			called = true
+			c.Byte(http.StatusServiceUnavailable, "text/plain", []byte("recovered"))
+		},
+	}))
+	defer e.Shutdown(context.Background())
+
+	resp, err := http.Get(base + "/panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable || string(b) != "recovered" || !called {
+		t.Fatalf("panic response code is %d , body is %s", resp.StatusCode, string(b))
+	}
+}
+
+func TestRecoveryConfig(t *testing.T) {
+	conf := &RecoveryConfig{Stack: true}
+	Recovery(conf)
+	if conf.StackSize != 0 {
+		t.Fatalf("conf is modified to %+v", conf)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	e, base := startEngine(t, RequestID())
+	defer e.Shutdown(context.Background())
+
+	req, _ := http.NewRequest("GET", base+"/request_id", nil)
+	req.Header.Set(HeaderRequestID, "abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "abc" || resp.Header.Get(HeaderRequestID) != "abc" {
+		t.Fatalf("request id is %s , header is %s", string(b), resp.Header.Get(HeaderRequestID))
+	}
+
+	resp, err = http.Get(base + "/request_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if len(b) == 0 || resp.Header.Get(HeaderRequestID) != string(b) {
+		t.Fatalf("generated request id is %s , header is %s", string(b), resp.Header.Get(HeaderRequestID))
+	}
+}
+
+func TestCORS(t *testing.T) {
+	e, base := startEngine(t, CORS(&CORSConfig{
+		AllowOrigins:     []string{"http://conan.io"},
+		AllowMethods:     []string{"GET"},
+		AllowCredentials: true,
+	}))
+	defer e.Shutdown(context.Background())
+
+	req, _ := http.NewRequest("OPTIONS", base+"/hello", nil)
+	req.Header.Set("Origin", "http://conan.io")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent ||
+		resp.Header.Get("Access-Control-Allow-Origin") != "http://conan.io" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET" {
+		t.Fatalf("preflight code is %d , header is %v", resp.StatusCode, resp.Header)
+	}
+
+	req, _ = http.NewRequest("OPTIONS", base+"/hello", nil)
+	req.Header.Set("Origin", "http://other.io")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("preflight from other origin code is %d", resp.StatusCode)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	e, base := startEngine(t, Gzip(nil))
+	defer e.Shutdown(context.Background())
+
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding is %s", resp.Header.Get("Content-Encoding"))
+	}
+	r, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(r)
+	if string(b) != "hello world" {
+		t.Fatalf("body is %s", string(b))
+	}
+
+	// 没有body的响应 不压缩
+	req, _ = http.NewRequest("GET", base+"/empty", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ = ioutil.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Encoding") != "" || len(b) != 0 {
+		t.Fatalf("Content-Encoding is %s , body is %q", resp.Header.Get("Content-Encoding"), b)
+	}
+}
+
+func TestBBR(t *testing.T) {
+	limiter := bbr.NewLimiter(nil)
+	e, base := startEngine(t, BBR(limiter))
+	defer e.Shutdown(context.Background())
+
+	for _, path := range []string{"/hello", "/panic"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if stat := limiter.Stat(); stat.InFlight != 0 {
+		t.Fatalf("stat is %+v", stat)
+	}
+
+	// 默认不使用header中的优先级 避免客户端绕过限流
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	req.Header.Set(HeaderPriority, "high")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if stat := limiter.Stat().Priorities[2]; stat.Priority != bbr.PriorityHigh || stat.Pass != 0 {
+		t.Fatalf("high priority stat is %+v", stat)
+	}
+}
+
+func TestTrustPriorityHeader(t *testing.T) {
+	limiter := bbr.NewLimiter(nil)
+	e, base := startEngine(t, BBR(limiter, TrustPriorityHeader()))
+	defer e.Shutdown(context.Background())
+
+	// 优先级从header中读取
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	req.Header.Set(HeaderPriority, "low")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if stat := limiter.Stat().Priorities[0]; stat.Priority != bbr.PriorityLow || stat.Pass != 1 {
+		t.Fatalf("low priority stat is %+v", stat)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	limiter := bbr.NewLimiter(nil)
+	e, base := startEngine(t, Priority(bbr.PriorityHigh), BBR(limiter, TrustPriorityHeader()))
+	defer e.Shutdown(context.Background())
+
+	// Priority 覆盖header中的优先级
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	req.Header.Set(HeaderPriority, "low")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if stat := limiter.Stat().Priorities[2]; stat.Priority != bbr.PriorityHigh || stat.Pass != 1 {
+		t.Fatalf("high priority stat is %+v", stat)
+	}
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"conan/core/server"
+	"conan/log"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"runtime"
+)
+
+type RecoveryConfig struct {
+	Stack     bool                                     // 是否在日志中输出堆栈
+	StackSize int                                      // 堆栈的最大长度 默认为4K
+	Handler   func(c *server.Context, err interface{}) // 自定义的响应 为空时返回500
+}
+
+var (
+	defaultRecoveryConf = &RecoveryConfig{
+		Stack:     true,
+		StackSize: 4 << 10,
+	}
+	default500Body = []byte("500 System Error")
+)
+
+// Recovery 捕获后续handler中的panic 记录日志后 返回500 或者交给 conf.Handler 处理
+func Recovery(conf *RecoveryConfig) server.HandlerFunc {
+	if conf == nil {
+		conf = defaultRecoveryConf
+	}
+	// 复制一份再设置默认值 不修改调用方的配置
+	cp := *conf
+	conf = &cp
+	if conf.StackSize <= 0 {
+		conf.StackSize = defaultRecoveryConf.StackSize
+	}
+
+	return func(c *server.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// 这个panic 是 net/http 用来中断请求的 需要继续抛出
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			fields := []zap.Field{
+				zap.String("method", c.Req.Method),
+				zap.String("path", c.Req.URL.Path),
+				zap.String("panic", fmt.Sprintf("%v", err)),
+				zap.String("request_id", GetRequestID(c.Ctx)),
+			}
+			if conf.Stack {
+				buf := make([]byte, conf.StackSize)
+				n := runtime.Stack(buf, false)
+				fields = append(fields, zap.ByteString("stack", buf[:n]))
+			}
+			log.ErrorFields("http server panic", fields...)
+
+			// 已经写入了部分响应 这时无法再修改状态码 只能中断后续的handler
+			if c.Res.Written() {
+				c.Abort()
+				return
+			}
+
+			if conf.Handler != nil {
+				conf.Handler(c, err)
+			} else {
+				c.Byte(http.StatusInternalServerError, "text/plain; chatset=utf-8", default500Body)
+			}
+			c.Abort()
+		}()
+
+		c.Next()
+	}
+}
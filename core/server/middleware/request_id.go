@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"conan/core/server"
+	"conan/log"
+	"conan/utils"
+	"context"
+)
+
+const (
+	HeaderRequestID = "X-Request-Id"
+
+	maxRequestIDLen = 128
+)
+
+type requestIDKey struct{}
+
+// RequestID 若请求中带有 X-Request-Id 则沿用 否则生成一个新的
+// request id 会写入响应头 并保存在 c.Ctx 中 通过 GetRequestID 获取
+func RequestID() server.HandlerFunc {
+	return func(c *server.Context) {
+		id := c.Req.Header.Get(HeaderRequestID)
+		if id == "" || len(id) > maxRequestIDLen {
+			uid, err := utils.GetUUID()
+			if err != nil {
+				log.Error("RequestID Generate UUID Fail err is %s", err.Error())
+				c.Next()
+				return
+			}
+			id = uid.String()
+		}
+
+		c.Res.Header().Set(HeaderRequestID, id)
+		c.Ctx = context.WithValue(c.Ctx, requestIDKey{}, id)
+		c.Next()
+	}
+}
+
+// GetRequestID 返回 ctx 中保存的 request id 不存在时返回空字符串
+func GetRequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -1,74 +1,57 @@
 package server
 
 import (
-	"bytes"
-	"fmt"
-	"net/http"
-	"strings"
-	"time"
+	"conan/core/client"
+	"conan/core/server/binding"
 	"conan/core/server/rending"
-	//"conan/ecode"
+	"context"
+	"net/http"
+	"net/url"
 )
 
 var (
-	cli *http.Client
+	// 没有配置 BaseURLs 请求时使用完整的url 超时时间为 client 默认的5s
+	cli, _ = client.NewClient(nil)
 )
 
-func init() {
-	cli = &http.Client{}
-	cli.Timeout = 5 * time.Second
-}
-
-//func HttpCode(c *Context, msgs ecode.ErrMsgs) {
-//	c.Json(nil, msgs)
-//}
-//
-//func HttpData(c *Context, data interface{}) {
-//	c.Json(data, ecode.OK)
-//}
+// HttpJsonPost 将 data 序列化为json 然后 POST 到 urlStr
 //
-//func BackCode(urlStr string, code ecode.ErrMsgs) (*http.Response, error) {
-//	return HttpJsonPost(urlStr, &rending.Json{
-//		Code: code.Code(),
-//		Msg:  code.Error(),
-//		Data: nil,
-//	})
-//}
-//
-//func BackData(urlStr string, data interface{}) (*http.Response, error) {
-//	return HttpJsonPost(urlStr, &rending.Json{
-//		Code: ecode.OK.Code(),
-//		Msg:  ecode.OK.Error(),
-//		Data: data,
-//	})
-//}
-
+// Deprecated: 使用 conan/core/client 中的 Client.PostJson 或者 Client.Do
 func HttpJsonPost(urlStr string, data *rending.Json) (*http.Response, error) {
-
 	b, _ := data.ToJson()
-
-	return cli.Post(urlStr, "application/json", bytes.NewReader(b))
+	return cli.Do(context.Background(), &client.Request{
+		Method: http.MethodPost,
+		Path:   urlStr,
+		Header: http.Header{"Content-Type": []string{binding.MEINJSON}},
+		Body:   b,
+	})
 }
 
+// HttpGet arg 会作为 query 拼接到 url 之后
+//
+// Deprecated: 使用 conan/core/client 中的 Client.Get 或者 Client.Do
 func HttpGet(url string, arg map[string]string) (*http.Response, error) {
+	return cli.Do(context.Background(), &client.Request{
+		Method: http.MethodGet,
+		Path:   url,
+		Query:  toValues(arg),
+	})
+}
 
-	builder := strings.Builder{}
-
-	builder.WriteString(url)
-
-	builder.WriteString("?")
+func toValues(arg map[string]string) url.Values {
+	if len(arg) == 0 {
+		return nil
+	}
+	values := make(url.Values, len(arg))
 	for k, v := range arg {
-		builder.WriteString(fmt.Sprintf("%s=%s&", k, v))
+		values.Set(k, v)
 	}
-
-	urlStr := builder.String()
-	urlStr = urlStr[:len(urlStr)-1]
-
-	return cli.Get(urlStr)
+	return values
 }
 
+// Close 关闭 HttpJsonPost HttpGet 使用的空闲连接
+//
+// Deprecated: 使用 conan/core/client 中的 Client.Close
 func Close() {
-	if cli != nil {
-		cli.CloseIdleConnections()
-	}
+	cli.Close()
 }
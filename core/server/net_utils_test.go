@@ -0,0 +1,33 @@
+package server
+
+import (
+	"conan/core/server/rending"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNetUtils(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.URL.RawQuery + " " + r.Header.Get("Content-Type") + " " + string(b)))
+	}))
+	defer srv.Close()
+	defer Close()
+
+	read := func(resp *http.Response, err error) string {
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return string(b)
+	}
+	if s := read(HttpGet(srv.URL+"/get", map[string]string{"q": "a b"})); s != "GET q=a+b  " {
+		t.Fatalf("get resp is %s", s)
+	}
+	if s := read(HttpJsonPost(srv.URL+"/post", &rending.Json{Data: 1})); s != `POST  application/json {"data":1}` {
+		t.Fatalf("post resp is %s", s)
+	}
+}
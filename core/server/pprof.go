@@ -1,7 +1,6 @@
 package server
 
 import (
-	"net/http"
 	"net/http/pprof"
 )
 
@@ -24,21 +23,15 @@ func startPProf(e *Engine) {
 
 	*/
 
-	e.GET("/debug/pprof/", pprofHandler(pprof.Index))
-
-	e.GET("/debug/pprof/allocs", pprofHandler(pprof.Handler("allocs").ServeHTTP))
-	e.GET("/debug/pprof/block", pprofHandler(pprof.Handler("block").ServeHTTP))
-	e.GET("/debug/pprof/cmdline", pprofHandler(pprof.Cmdline))
-	e.GET("/debug/pprof/goroutine", pprofHandler(pprof.Handler("goroutine").ServeHTTP))
-	e.GET("/debug/pprof/heap", pprofHandler(pprof.Handler("heap").ServeHTTP))
-	e.GET("/debug/pprof/mutex", pprofHandler(pprof.Handler("mutex").ServeHTTP))
-	e.GET("/debug/pprof/profile", pprofHandler(pprof.Profile))
-	e.GET("/debug/pprof/threadcreate", pprofHandler(pprof.Handler("threadcreate").ServeHTTP))
-	e.GET("/debug/pprof/trace", pprofHandler(pprof.Trace))
-}
-
-func pprofHandler(h http.HandlerFunc) HandlerFunc {
-	return func(c *Context) {
-		h.ServeHTTP(c.Res, c.Req)
-	}
+	e.GET("/debug/pprof/", WrapF(pprof.Index))
+
+	e.GET("/debug/pprof/allocs", WrapF(pprof.Handler("allocs").ServeHTTP))
+	e.GET("/debug/pprof/block", WrapF(pprof.Handler("block").ServeHTTP))
+	e.GET("/debug/pprof/cmdline", WrapF(pprof.Cmdline))
+	e.GET("/debug/pprof/goroutine", WrapF(pprof.Handler("goroutine").ServeHTTP))
+	e.GET("/debug/pprof/heap", WrapF(pprof.Handler("heap").ServeHTTP))
+	e.GET("/debug/pprof/mutex", WrapF(pprof.Handler("mutex").ServeHTTP))
+	e.GET("/debug/pprof/profile", WrapF(pprof.Profile))
+	e.GET("/debug/pprof/threadcreate", WrapF(pprof.Handler("threadcreate").ServeHTTP))
+	e.GET("/debug/pprof/trace", WrapF(pprof.Trace))
 }
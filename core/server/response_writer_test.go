@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := newResponseWriter(rec)
+
+	if w.Written() || w.Size() != noWritten || w.Status() != http.StatusOK {
+		t.Fatalf("new writer status is %d , size is %d", w.Status(), w.Size())
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	if w.Written() {
+		t.Fatal("WriteHeader should not write header immediately")
+	}
+	w.Header().Set("Content-Type", "text/plain")
+
+	w.Write([]byte("hello"))
+	w.WriteHeader(http.StatusInternalServerError)
+
+	if rec.Code != http.StatusCreated || w.Status() != http.StatusCreated {
+		t.Fatalf("status is %d , recorder code is %d", w.Status(), rec.Code)
+	}
+	if w.Size() != 5 || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("size is %d , Content-Type is %s", w.Size(), rec.Header().Get("Content-Type"))
+	}
+
+	if _, _, err := w.Hijack(); err != http.ErrNotSupported {
+		t.Fatalf("Hijack err is %v", err)
+	}
+	if err := w.Push("/a.css", nil); err != http.ErrNotSupported {
+		t.Fatalf("Push err is %v", err)
+	}
+	w.Flush()
+	if !rec.Flushed {
+		t.Fatal("Flush should pass through")
+	}
+}
+
+func TestPanicAfterWrite(t *testing.T) {
+	e := newTestEngine(nil)
+	e.GET("/partial", func(c *Context) {
+		c.Res.Write([]byte("partial"))
+		panic("boom")
+	})
+	e.GET("/status", func(c *Context) {
+		c.Status(http.StatusAccepted)
+	})
+
+	w := doRequest(e, "GET", "/partial")
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Fatalf("code is %d , body is %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(e, "GET", "/status")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("code is %d", w.Code)
+	}
+}
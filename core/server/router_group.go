@@ -1,15 +1,38 @@
 package server
 
-import "conan/utils"
+import (
+	"conan/utils"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	// Any 注册的method
+	anyMethods = []string{
+		http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodHead, http.MethodOptions, http.MethodDelete, http.MethodConnect,
+		http.MethodTrace,
+	}
+)
 
 type IRouter interface {
 	UseFunc(middle ...HandlerFunc) IRouter
+	NewGroup(basePath string, handlers ...HandlerFunc) IRouter
 
+	Handle(method string, router string, handlerFunc ...HandlerFunc) IRouter
+	Any(router string, handlerFunc ...HandlerFunc) IRouter
 	PUT(router string, handlerFunc ...HandlerFunc) IRouter
 	POST(router string, handlerFunc ...HandlerFunc) IRouter
 	GET(router string, handlerFunc ...HandlerFunc) IRouter
 	DELETE(router string, handlerFunc ...HandlerFunc) IRouter
 	HEAD(router string, handlerFunc ...HandlerFunc) IRouter
+	PATCH(router string, handlerFunc ...HandlerFunc) IRouter
+	OPTIONS(router string, handlerFunc ...HandlerFunc) IRouter
+
+	Mount(prefix string, handler http.Handler) IRouter
+
+	WithTimeout(timeout time.Duration) IRouter
 }
 
 type RouterGroup struct {
@@ -17,6 +40,7 @@ type RouterGroup struct {
 	router   bool
 	BasePath string
 	e        *Engine
+	timeout  time.Duration // 通过该group注册的路由的超时时间
 }
 
 func (r *RouterGroup) NewGroup(basePath string, handlers ...HandlerFunc) IRouter {
@@ -25,6 +49,19 @@ func (r *RouterGroup) NewGroup(basePath string, handlers ...HandlerFunc) IRouter
 		router:   false,
 		BasePath: r.calcAbsPath(basePath),
 		e:        r.e,
+		timeout:  r.timeout,
+	}
+}
+
+// WithTimeout 返回一个设置了超时时间的group 通过它注册的路由 以及它的子group 都会使用该超时时间
+// eg: e.WithTimeout(30 * time.Second).POST("/upload", upload)
+func (r *RouterGroup) WithTimeout(timeout time.Duration) IRouter {
+	return &RouterGroup{
+		Handlers: r.combineHandlers(),
+		router:   false,
+		BasePath: r.BasePath,
+		e:        r.e,
+		timeout:  timeout,
 	}
 }
 
@@ -52,7 +89,7 @@ func (r *RouterGroup) hand(method string, router string, handlers ...HandlerFunc
 
 	finalHandlers := r.combineHandlers(handlers...)
 
-	r.e.addRouter(method, finalPath, finalHandlers...)
+	r.e.addRouter(method, finalPath, r.timeout, finalHandlers...)
 	return r
 }
 
@@ -80,3 +117,61 @@ func (r *RouterGroup) HEAD(router string, handlers ...HandlerFunc) IRouter {
 	r.hand("HEAD", router, handlers...)
 	return r
 }
+
+func (r *RouterGroup) PATCH(router string, handlers ...HandlerFunc) IRouter {
+	r.hand("PATCH", router, handlers...)
+	return r
+}
+
+func (r *RouterGroup) OPTIONS(router string, handlers ...HandlerFunc) IRouter {
+	r.hand("OPTIONS", router, handlers...)
+	return r
+}
+
+// Handle 用于注册 非常用的method eg: PROPFIND
+func (r *RouterGroup) Handle(method string, router string, handlers ...HandlerFunc) IRouter {
+	for i := 0; i < len(method); i++ {
+		if method[i] < 'A' || method[i] > 'Z' {
+			panic("http method " + method + " is not valid")
+		}
+	}
+	r.hand(method, router, handlers...)
+	return r
+}
+
+// Any 为所有的method 注册同一个路由
+func (r *RouterGroup) Any(router string, handlers ...HandlerFunc) IRouter {
+	for _, method := range anyMethods {
+		r.hand(method, router, handlers...)
+	}
+	return r
+}
+
+// Mount 将一个 http.Handler 挂载到 prefix 下 所有method 都会交给该 handler 处理
+// 交给 handler 的请求中 path 会去掉 prefix eg: Mount("/admin", h) 请求 /admin/users 时 h 收到的path为 /users
+// 不能挂载到 / 下 会和 /allRouter 等内置的路由冲突 需要时使用 Engine.NoRouter(WrapH(h))
+func (r *RouterGroup) Mount(prefix string, handler http.Handler) IRouter {
+	absPrefix := strings.TrimSuffix(r.calcAbsPath(prefix), "/")
+	if absPrefix == "" {
+		panic("can not mount handler at / , use Engine.NoRouter(WrapH(handler)) instead")
+	}
+	h := WrapH(http.StripPrefix(absPrefix, handler))
+
+	r.Any(prefix, h)
+	r.Any(utils.JoinPath(prefix, "/*filepath"), h)
+	return r
+}
+
+// WrapF 将 http.HandlerFunc 转换为 HandlerFunc
+func WrapF(f http.HandlerFunc) HandlerFunc {
+	return func(c *Context) {
+		f(c.Res, c.Req)
+	}
+}
+
+// WrapH 将 http.Handler 转换为 HandlerFunc
+func WrapH(h http.Handler) HandlerFunc {
+	return func(c *Context) {
+		h.ServeHTTP(c.Res, c.Req)
+	}
+}
@@ -2,35 +2,67 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+	"conan/breaker"
 	"conan/config"
 	"conan/log"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
 	MAX_MEM = 32 << 20 // 32M
 	CLOSED  = 1
 	START   = 2
+
+	// 客户端通过该请求头 告知服务端 本次请求的超时时间 单位ms
+	HeaderRequestTimeout = "X-Request-Timeout"
 )
 
 var (
 	default404Body = []byte("404 page not Found")
 	default405Body = []byte("405 method not allowed")
-	default505Body = []byte("505 System Error")
+	default503Body = []byte("503 server is closed")
+	default500Body = []byte("500 System Error")
 	defaultConf    *EngConfig
+
+	ErrServerRunning = errors.New("http server is already running")
 )
 
 type EngConfig struct {
-	Network      string `yaml:"network"`
-	Addr         string `yaml:"addr"`
-	IdleTimeout  int64  `yaml:"idleTimeout"`
-	ReadTimeout  int64  `yaml:"readTimeout"`
-	WriteTimeout int64  `yaml:"writeTimeout"`
+	Network       string `yaml:"network"`
+	Addr          string `yaml:"addr"`
+	IdleTimeout   int64  `yaml:"idleTimeout"`
+	ReadTimeout   int64  `yaml:"readTimeout"`
+	WriteTimeout  int64  `yaml:"writeTimeout"`
+	HandleOptions bool   `yaml:"handleOptions"` // 是否自动应答 OPTIONS 请求
+	// 关闭时 等待正在处理的请求完成的最长时间 单位s 为0时一直等待
+	ShutdownTimeout int64 `yaml:"shutdownTimeout"`
+	// 每个请求默认的超时时间 单位ms 为0时不限制
+	// 同时也是请求头 X-Request-Timeout 的上限
+	Timeout int64 `yaml:"timeout"`
+	// 可信的代理 可以是ip 或者 CIDR 只有来自这些代理的请求 ClientIP 才会使用 X-Forwarded-For
+	TrustedProxies []string `yaml:"trustedProxies"`
+	// 不为空时 使用 https 同时支持 HTTP/2
+	TLS *TLSConfig `yaml:"tls"`
+	// 未开启TLS时 是否支持明文的 HTTP/2 (h2c) 一般用于内部服务之间的调用
+	H2C bool `yaml:"h2c"`
+	// 除 Network Addr 之外的其他监听 eg: 管理端口 unix socket systemd 传递的fd
+	Listeners []*ListenerConfig `yaml:"listeners"`
+	// 平滑升级时 等待新进程启动完成的最长时间 单位s 为0时为60s
+	UpgradeTimeout int64 `yaml:"upgradeTimeout"`
+	// 准入控制 为空时不限制同时处理的请求数
+	Admission *AdmissionConfig `yaml:"admission"`
 }
 
 func init() {
@@ -45,16 +77,33 @@ type HandlerFunc func(*Context)
 type Engine struct {
 	RouterGroup
 	cfg       *EngConfig
-	serve     atomic.Value
-	wg        *sync.WaitGroup
+	wg        *sync.WaitGroup // 用于等待 Serve 协程
 	router    methodTrees
 	allRouter map[string]map[string]struct{} // method -> path
 	closed    int32                          // 1 为关闭 2为开启
 	//Handlers []HandlerFunc
-	noRouter []HandlerFunc
-	noMethod []HandlerFunc
+	noRouter    []HandlerFunc // 通过 NoRouter 设置的 handler
+	noMethod    []HandlerFunc // 通过 NoMethod 设置的 handler
+	allNoRouter []HandlerFunc // 加上中间件之后 最终 404 时执行的 handler
+	allNoMethod []HandlerFunc // 加上中间件之后 最终 405 时执行的 handler
+
+	admit *admission // 准入控制 未开启时为nil
+
+	breakerMu sync.RWMutex
+	breakers  []*breaker.Group // 通过 RegisterBreakerGroup 注册 在 /allBreaker 中展示
+
+	pool      sync.Pool // Context 的复用池
+	maxParams uint16    // 所有路由中 参数最多的个数 用于预分配 Context.Params
+
+	trustedCIDRs []*net.IPNet  // 由 EngConfig.TrustedProxies 解析而来
+	certs        *certReloader // 开启TLS时 当前使用的证书
+	tlsConf      *tls.Config
+	httpHandler  http.Handler // 交给 http.Server 的handler 开启h2c时会包装一层
 
-	reqChan chan *Context
+	lnMu      sync.Mutex
+	listeners map[string]*listener // 正在运行的监听 name -> listener
+	pending   []*ListenerConfig    // 服务启动前 通过 AddListener 增加的监听
+	upgrading int32                // 是否正在平滑升级
 }
 
 //
@@ -83,27 +132,82 @@ func NewEngine(cfg *EngConfig) *Engine {
 		cfg:       cfg,
 		wg:        &sync.WaitGroup{},
 		router:    make(methodTrees, 0, 9),
-		serve:     atomic.Value{},
+		listeners: make(map[string]*listener),
 		allRouter: make(map[string]map[string]struct{}),
-		noRouter:  make([]HandlerFunc, 0),
-		noMethod:  make([]HandlerFunc, 0),
-		reqChan:   make(chan *Context, 1000), // 这里的1000 现在是随便给的
+		noRouter:  []HandlerFunc{default404Handler},
+		noMethod:  []HandlerFunc{default405Handler},
+		admit:     newAdmission(cfg.Admission),
 		closed:    CLOSED,
 	}
 	e.e = e
+	trustedCIDRs, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		panic("Service Parse TrustedProxies Fail " + err.Error())
+	}
+	e.trustedCIDRs = trustedCIDRs
+	e.pool.New = func() interface{} {
+		return e.allocateContext()
+	}
+	e.rebuild404Handler()
+	e.rebuild405Handler()
 	// 加入pprof路由
 	startPProf(e)
-	e.addRouter("GET", "/allRouter", e.allRouters)
+	e.addRouter("GET", "/allRouter", 0, e.allRouters)
+	e.addRouter("GET", "/allBreaker", 0, e.allBreakers)
 	return e
 }
 
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", p)
+			}
+			bits := net.IPv4len * 8
+			if ip.To4() == nil {
+				bits = net.IPv6len * 8
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+func (e *Engine) isTrustedProxy(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range e.trustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Engine) allocateContext() *Context {
+	c := &Context{
+		e:      e,
+		Params: make(Params, 0, e.maxParams),
+	}
+	c.Res = &c.writermem
+	return c
+}
+
 func (e *Engine) allRouters(c *Context) {
 	if err := c.Json(e.allRouter, nil); err != nil {
 		log.Error("all Routers Fail err is %s", err.Error())
 	}
 }
 
-func (e *Engine) addRouter(method string, path string, handler ...HandlerFunc) {
+func (e *Engine) addRouter(method string, path string, timeout time.Duration, handler ...HandlerFunc) {
 	if path[0] != '/' {
 		panic("path mast begin with '/'")
 	}
@@ -136,43 +240,76 @@ func (e *Engine) addRouter(method string, path string, handler ...HandlerFunc) {
 		e.router = append(e.router, methodTree{root: router, method: method})
 	}
 
-	prelude := func(c *Context) {
-		c.Method = method
-		c.RouterPath = path
-		log.Debug("router method is %s , path is %s ", method, path)
+	leaf := router.addRouter(path, handler...)
+	leaf.timeout = timeout
+	if n := countParams(path); n > e.maxParams {
+		e.maxParams = n
 	}
-
-	handlers := append([]HandlerFunc{prelude}, handler...)
-	router.addRouter(path, handlers...)
 	log.Info("Add Router  method is %s , path is %s", method, path)
+}
 
-	e.NoMethod(func(c *Context) {
-		c.Byte(404, "text/plain; chatset=utf-8", default404Body)
-		c.Abort()
-	})
+func default404Handler(c *Context) {
+	c.Byte(http.StatusNotFound, "text/plain; chatset=utf-8", default404Body)
+	c.Abort()
+}
 
-	e.NoRouter(func(c *Context) {
-		c.Byte(405, "text/plain; chatset=utf-8", default405Body)
-		c.Abort()
-	})
+func default405Handler(c *Context) {
+	c.Byte(http.StatusMethodNotAllowed, "text/plain; chatset=utf-8", default405Body)
+	c.Abort()
+}
+
+func defaultOptionsHandler(c *Context) {
+	c.Status(http.StatusNoContent)
+	c.Abort()
+}
+
+// UseFunc 这里需要重新构建 404 和 405 的handler 让中间件对其也生效
+func (e *Engine) UseFunc(middle ...HandlerFunc) IRouter {
+	e.RouterGroup.UseFunc(middle...)
+	e.rebuild404Handler()
+	e.rebuild405Handler()
+	return e
 }
 
+// NoRouter 设置 path 不存在时的handler
 func (e *Engine) NoRouter(handler ...HandlerFunc) {
 	e.noRouter = handler
 	e.rebuild404Handler()
 }
 
+// NoMethod 设置 path 存在 但是 method 不匹配时的handler 此时响应中已经设置好了 Allow
 func (e *Engine) NoMethod(handler ...HandlerFunc) {
 	e.noMethod = handler
 	e.rebuild405Handler()
 }
 
 func (e *Engine) rebuild404Handler() {
-	e.noRouter = e.combineHandlers(e.noRouter...)
+	e.allNoRouter = e.combineHandlers(e.noRouter...)
 }
 
 func (e *Engine) rebuild405Handler() {
-	e.noMethod = e.combineHandlers(e.noMethod...)
+	e.allNoMethod = e.combineHandlers(e.noMethod...)
+}
+
+// 返回 path 在其他 method 下注册过的 method 列表 以 ", " 分割
+// path 为 "*" 时 返回所有注册过的 method
+func (e *Engine) allowed(path string, reqMethod string) string {
+	allowed := make([]string, 0, len(e.router)+1)
+
+	for _, tree := range e.router {
+		if tree.method == reqMethod || tree.method == http.MethodOptions {
+			continue
+		}
+		if path != "*" && tree.root.getHandler(path) == nil {
+			continue
+		}
+		allowed = append(allowed, tree.method)
+	}
+
+	if len(allowed) > 0 && e.cfg.HandleOptions {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return strings.Join(allowed, ", ")
 }
 
 func (e *Engine) isClosed() bool {
@@ -185,117 +322,221 @@ func (e *Engine) isClosed() bool {
 
 func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
+	// 正在处理的请求由 http.Server.Shutdown 等待 这里不再计数
+	// 否则 Shutdown Wait 的同时 仍在存活的连接会从0开始 Add 属于 WaitGroup 的误用
 	if e.isClosed() {
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(default503Body)
 		return
 	}
 
-	c := &Context{
-		Res:     w,
-		Req:     r,
-		index:   -1,
-		e:       e,
-		handler: nil,
-		Ctx:     nil,
+	// 排队时不切换协程 请求始终在 ServeHTTP 的协程中处理 w 在处理完成之前一直有效
+	if e.admit != nil {
+		if err := e.admit.acquire(r.Context()); err != nil {
+			e.admit.reject(w)
+			return
+		}
+		defer e.admit.release()
 	}
 
+	c := e.pool.Get().(*Context)
+	c.writermem.reset(w)
+	c.Req = r
+	c.reset()
+
 	e.HandlerContext(c)
-	//
-	//// 进行一个排队
-	// TODO: 这里不能这样排队 应为过channel 会发生复制 放到channel后本次ServerHTTP就退出了 GC会把w也释放掉，所以会造成Write报错
-	//select {
-	//case e.reqChan <- c:
-	//default:
-	//}
 
+	// 放回池中之后 c 不能再被使用 需要在其他协程中使用时 调用 c.Copy()
+	e.pool.Put(c)
 }
 
-//
-//func (e *Engine) handler() {
-//
-//	for {
-//		select {
-//		case ctx, ok := <-e.reqChan:
-//			if !ok {
-//				e.wg.Done()
-//				return
-//			}
-//			e.HandlerContext(ctx)
-//		}
-//
-//	}
-//
-//}
-
 func (e *Engine) HandlerContext(ctx *Context) {
+	// form 不在这里解析 需要的时候调用 ctx.ParseForm
+	// 客户端断开连接 或者 Server 关闭时 ctx.Ctx 都会被取消
+	ctx.Ctx = ctx.Req.Context()
+
+	defer e.recovery(ctx)
 
-	// parseFrom
-	cType := ctx.Req.Header.Get("Content-Type")
-	switch {
-	case strings.Contains(cType, "multipart/form-data"):
-		ctx.Req.ParseMultipartForm(MAX_MEM)
-	default:
-		ctx.Req.ParseForm()
+	if timeout := e.timeout(ctx.Req, e.prepareHandler(ctx)); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx.Ctx, cancel = context.WithTimeout(ctx.Ctx, timeout)
+		defer cancel()
 	}
-	var cancel context.CancelFunc
-	ctx.Ctx, cancel = context.WithCancel(context.TODO())
-	defer cancel()
 
-	e.prepareHandler(ctx)
 	ctx.Next()
+	// handler 只设置了状态码 没有写入body的时候 这里需要把header写入
+	ctx.Res.WriteHeaderNow()
 }
 
-func (e *Engine) prepareHandler(c *Context) {
+// 兜底的panic处理 一般情况下 panic 应该由 middleware.Recovery 处理
+func (e *Engine) recovery(c *Context) {
+	if err := recover(); err != nil {
+		buf := make([]byte, 4096)
+		n := runtime.Stack(buf, false)
+		log.Error("http server recover  is %s", fmt.Sprintf("http server panic: %v\n%s\n", err, buf[:n]))
+		// 已经写入了部分响应 这时无法再修改状态码
+		if c.Res.Written() {
+			return
+		}
+		c.Byte(http.StatusInternalServerError, "text/plain; chatset=utf-8", default500Body)
+	}
+}
+
+// 计算本次请求的超时时间 路由上设置了超时时间时 使用路由的 否则使用 EngConfig.Timeout
+// 请求头中的 X-Request-Timeout 只能缩短超时时间 不能超过服务端的设置
+func (e *Engine) timeout(r *http.Request, routeTimeout time.Duration) time.Duration {
+	timeout := routeTimeout
+	if timeout <= 0 {
+		timeout = time.Duration(e.cfg.Timeout) * time.Millisecond
+	}
+
+	if v := r.Header.Get(HeaderRequestTimeout); v != "" {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || ms <= 0 {
+			return timeout
+		}
+		if reqTimeout := time.Duration(ms) * time.Millisecond; timeout <= 0 || reqTimeout < timeout {
+			timeout = reqTimeout
+		}
+	}
+	return timeout
+}
+
+// 找到本次请求对应的handler 返回路由上设置的超时时间
+func (e *Engine) prepareHandler(c *Context) time.Duration {
 	method := c.Req.Method
 	path := c.Req.URL.Path
 
-	h := e.router.getRoot(method).getHandler(path)
-	if h == nil {
-		c.handler = e.noRouter
-		return
+	if root := e.router.getRoot(method); root != nil {
+		value := root.getValue(path, &c.Params)
+		if value.handler != nil {
+			c.handler = value.handler
+			c.Method = method
+			c.RouterPath = value.fullPath
+			return value.timeout
+		}
 	}
 
-	c.handler = h
-	return
+	// 这里表示 当前method下没有该path 需要区分 是path不存在 还是method不对
+	if method == http.MethodOptions && e.cfg.HandleOptions {
+		if allow := e.allowed(path, method); allow != "" {
+			c.Res.Header().Set("Allow", allow)
+			c.handler = e.combineHandlers(defaultOptionsHandler)
+			return 0
+		}
+	} else if allow := e.allowed(path, method); allow != "" {
+		c.Res.Header().Set("Allow", allow)
+		c.handler = e.allNoMethod
+		return 0
+	}
+
+	c.handler = e.allNoRouter
+	return 0
 }
 
-func (e *Engine) RunServer() {
-	ser := &http.Server{
-		Addr:         e.cfg.Addr,
-		Handler:      e,
-		IdleTimeout:  time.Duration(e.cfg.IdleTimeout) * time.Second,
-		ReadTimeout:  time.Duration(e.cfg.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(e.cfg.WriteTimeout) * time.Second,
+// RunServer 会同步的监听 Addr 监听失败时直接返回错误 成功后在后台处理请求
+func (e *Engine) RunServer() error {
+	if !e.isClosed() {
+		return ErrServerRunning
 	}
 
-	e.serve.Store(ser)
-	atomic.StoreInt32(&e.closed, START)
+	e.tlsConf = nil
+	e.httpHandler = e
+	if e.cfg.TLS != nil {
+		tlsConf, certs, err := buildTLSConfig(e.cfg.TLS)
+		if err != nil {
+			log.Error("RunServer Build TLS Config Fail err is %s", err.Error())
+			return err
+		}
+		e.tlsConf = tlsConf
+		e.certs = certs
+	} else if e.cfg.H2C {
+		e.httpHandler = h2c.NewHandler(e, &http2.Server{
+			IdleTimeout: time.Duration(e.cfg.IdleTimeout) * time.Second,
+		})
+	}
 
-	//e.wg.Add(1)
-	//go e.handler()
+	e.lnMu.Lock()
+	confs := append(e.listenerConfigs(), e.pending...)
+	e.pending = nil
+	e.lnMu.Unlock()
 
-	e.wg.Add(1)
-	go func() {
-		if err := ser.ListenAndServe(); err != nil {
-			log.Debug("RunServer ListenAndServer Err is %s ", err.Error())
+	// 启动监听前设置状态 继承的监听中可能已经有连接在等待 这些请求不能返回503
+	atomic.StoreInt32(&e.closed, START)
+	for _, conf := range confs {
+		if err := e.startListener(conf); err != nil {
+			// 有一个监听失败 则关闭已经启动的监听
+			atomic.StoreInt32(&e.closed, CLOSED)
+			e.stopListeners(context.Background())
+			e.closeCerts()
+			return err
 		}
-		e.wg.Done()
-	}()
-	log.Info("Http Server Start Addr is %s", e.cfg.Addr)
+	}
+
+	log.Info("Http Server Start listeners is %d , tls is %t", len(confs), e.tlsConf != nil)
+	// 由平滑升级启动时 通知父进程可以关闭了
+	notifyUpgradeReady()
+	return nil
 }
 
-func (e *Engine) Close() {
+// 关闭所有的监听 返回第一个错误
+func (e *Engine) stopListeners(ctx context.Context) error {
+	e.lnMu.Lock()
+	listeners := e.listeners
+	e.listeners = make(map[string]*listener)
+	e.lnMu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			if err := e.stopListener(ctx, l); err != nil {
+				errs <- err
+			}
+		}(l)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
 
-	if e.isClosed() {
-		return
+func (e *Engine) closeCerts() {
+	if e.certs != nil {
+		e.certs.Close()
+		e.certs = nil
 	}
+}
 
-	atomic.StoreInt32(&e.closed, CLOSED)
+// Shutdown 关闭所有的监听 并等待正在处理的请求完成
+// 等待的时间由 ctx 以及 EngConfig.ShutdownTimeout 共同决定 超时后会强制关闭剩余的连接
+func (e *Engine) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&e.closed, START, CLOSED) {
+		return nil
+	}
 
-	s := e.serve.Load().(*http.Server)
-	s.Close()
+	if e.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(e.cfg.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
 
-	close(e.reqChan)
+	defer e.closeCerts()
+
+	if err := e.stopListeners(ctx); err != nil {
+		// 这里表示超时了 还有请求没有处理完
+		return err
+	}
 
 	e.wg.Wait()
-	log.Info("Http Serve Stop Addr is %s", e.cfg.Addr)
+	log.Info("Http Server Stop")
+	return nil
+}
+
+func (e *Engine) Close() {
+	if err := e.Shutdown(context.Background()); err != nil {
+		log.Error("Http Serve Close Err is %s", err.Error())
+	}
 }
@@ -1,11 +1,19 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
+	"time"
 )
 
 type JsonStrcut struct {
@@ -102,3 +110,305 @@ func TestRouterGroup(t *testing.T) {
 	e.RunServer()
 	select {}
 }
+
+func newTestEngine(cfg *EngConfig) *Engine {
+	e := NewEngine(cfg)
+	atomic.StoreInt32(&e.closed, START)
+	return e
+}
+
+func doRequest(e *Engine, method string, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(method, path, nil)
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestNoRouterAndNoMethod(t *testing.T) {
+	e := newTestEngine(&EngConfig{HandleOptions: true})
+	e.GET("/users/:id", func(c *Context) {
+		c.Byte(200, "text/plain", []byte(c.Param("id")))
+	})
+	e.PUT("/users/:id", func(c *Context) {
+		c.Byte(200, "text/plain", []byte("put"))
+	})
+
+	w := doRequest(e, "GET", "/users/10")
+	if w.Code != 200 || w.Body.String() != "10" {
+		t.Fatalf("GET /users/10 code is %d , body is %s", w.Code, w.Body.String())
+	}
+
+	w = doRequest(e, "GET", "/nothing")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("GET /nothing code is %d , want 404", w.Code)
+	}
+
+	w = doRequest(e, "POST", "/users/10")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST /users/10 code is %d , want 405", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, PUT, OPTIONS" {
+		t.Fatalf("POST /users/10 Allow is %s", allow)
+	}
+
+	w = doRequest(e, "OPTIONS", "/users/10")
+	if w.Code != http.StatusNoContent || w.Header().Get("Allow") != "GET, PUT, OPTIONS" {
+		t.Fatalf("OPTIONS /users/10 code is %d , Allow is %s", w.Code, w.Header().Get("Allow"))
+	}
+
+	e.NoMethod(func(c *Context) {
+		c.Byte(http.StatusMethodNotAllowed, "text/plain", []byte("custom"))
+	})
+	w = doRequest(e, "DELETE", "/users/10")
+	if w.Code != http.StatusMethodNotAllowed || w.Body.String() != "custom" || w.Header().Get("Allow") == "" {
+		t.Fatalf("DELETE /users/10 code is %d , body is %s", w.Code, w.Body.String())
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestRunServerBindFail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	e := NewEngine(&EngConfig{Addr: ln.Addr().String()})
+	if err := e.RunServer(); err == nil {
+		t.Fatal("RunServer should fail when addr is in use")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	addr := freeAddr(t)
+	e := NewEngine(&EngConfig{Addr: addr, ShutdownTimeout: 3})
+	e.GET("/slow", func(c *Context) {
+		time.Sleep(300 * time.Millisecond)
+		c.Byte(200, "text/plain", []byte("done"))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RunServer(); err != ErrServerRunning {
+		t.Fatalf("second RunServer err is %v", err)
+	}
+
+	res := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			res <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		res <- string(b)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := e.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-res; body != "done" {
+		t.Fatalf("in-flight request body is %s", body)
+	}
+
+	if _, err := http.Get("http://" + addr + "/slow"); err == nil {
+		t.Fatal("server should not accept new connections after Shutdown")
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	e := newTestEngine(&EngConfig{Timeout: 1000})
+
+	var deadline time.Duration
+	handler := func(c *Context) {
+		d, ok := c.Deadline()
+		if !ok {
+			deadline = 0
+			return
+		}
+		deadline = time.Until(d)
+	}
+	e.GET("/default", handler)
+	e.WithTimeout(50 * time.Millisecond).GET("/route", handler)
+	g := e.NewGroup("/api").WithTimeout(3 * time.Second)
+	g.GET("/group", handler)
+	e.GET("/done", func(c *Context) {
+		select {
+		case <-c.Done():
+			c.Byte(http.StatusGatewayTimeout, "text/plain", []byte(c.Err().Error()))
+		case <-time.After(time.Second):
+			c.Byte(200, "text/plain", []byte("ok"))
+		}
+	})
+
+	cases := []struct {
+		path   string
+		header string
+		min    time.Duration
+		max    time.Duration
+	}{
+		{"/default", "", 900 * time.Millisecond, time.Second},
+		{"/route", "", 0, 50 * time.Millisecond},
+		{"/api/group", "", 2900 * time.Millisecond, 3 * time.Second},
+		{"/default", "20", 0, 20 * time.Millisecond},
+		{"/default", "5000", 900 * time.Millisecond, time.Second},
+		{"/default", "abc", 900 * time.Millisecond, time.Second},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", c.path, nil)
+		if c.header != "" {
+			r.Header.Set(HeaderRequestTimeout, c.header)
+		}
+		e.ServeHTTP(httptest.NewRecorder(), r)
+		if deadline <= c.min || deadline > c.max {
+			t.Fatalf("path %s header %s deadline is %s", c.path, c.header, deadline)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/done", nil)
+	r.Header.Set(HeaderRequestTimeout, "10")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout || w.Body.String() != context.DeadlineExceeded.Error() {
+		t.Fatalf("code is %d , body is %s", w.Code, w.Body.String())
+	}
+}
+
+// 用于压测的 ResponseWriter 不产生任何分配
+type benchWriter struct {
+	header http.Header
+}
+
+func newBenchWriter() *benchWriter {
+	return &benchWriter{header: make(http.Header)}
+}
+
+func (w *benchWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *benchWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *benchWriter) WriteHeader(code int) {}
+
+func benchEngine() *Engine {
+	e := newTestEngine(&EngConfig{})
+	e.GET("/static/ping", func(c *Context) {})
+	e.GET("/users/:id", func(c *Context) {})
+	return e
+}
+
+func TestStaticRouteZeroAllocs(t *testing.T) {
+	e := benchEngine()
+	w := newBenchWriter()
+	r := httptest.NewRequest("GET", "/static/ping", nil)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		e.ServeHTTP(w, r)
+	})
+	if allocs != 0 {
+		t.Fatalf("static route allocs is %v , want 0", allocs)
+	}
+
+	r = httptest.NewRequest("GET", "/users/10", nil)
+	allocs = testing.AllocsPerRun(100, func() {
+		e.ServeHTTP(w, r)
+	})
+	if allocs != 0 {
+		t.Fatalf("param route allocs is %v , want 0", allocs)
+	}
+}
+
+func BenchmarkStaticRoute(b *testing.B) {
+	e := benchEngine()
+	w := newBenchWriter()
+	r := httptest.NewRequest("GET", "/static/ping", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ServeHTTP(w, r)
+	}
+}
+
+func BenchmarkParamRoute(b *testing.B) {
+	e := benchEngine()
+	w := newBenchWriter()
+	r := httptest.NewRequest("GET", "/users/10", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.ServeHTTP(w, r)
+	}
+}
+
+func TestRouterMethods(t *testing.T) {
+	e := newTestEngine(&EngConfig{})
+	echo := func(c *Context) {
+		c.Byte(200, "text/plain", []byte(c.Req.Method+" "+c.RouterPath))
+	}
+
+	var g IRouter = e
+	g = g.NewGroup("/api").NewGroup("/v1")
+	g.PATCH("/patch", echo).OPTIONS("/options", echo).Handle("PROPFIND", "/dav", echo).Any("/any", echo)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mounted " + r.URL.Path))
+	})
+	g.Mount("/admin", mux)
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"PATCH", "/api/v1/patch", "PATCH /api/v1/patch"},
+		{"OPTIONS", "/api/v1/options", "OPTIONS /api/v1/options"},
+		{"PROPFIND", "/api/v1/dav", "PROPFIND /api/v1/dav"},
+		{"GET", "/api/v1/any", "GET /api/v1/any"},
+		{"DELETE", "/api/v1/any", "DELETE /api/v1/any"},
+		{"GET", "/api/v1/admin/users", "mounted /users"},
+		{"POST", "/api/v1/admin/users", "mounted /users"},
+	}
+	for _, c := range cases {
+		w := doRequest(e, c.method, c.path)
+		if w.Code != 200 || w.Body.String() != c.body {
+			t.Fatalf("%s %s code is %d , body is %s", c.method, c.path, w.Code, w.Body.String())
+		}
+	}
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Fatal("invalid method should panic")
+		}
+	}()
+	e.Handle("get", "/lower", echo)
+}
+
+func TestMountRoot(t *testing.T) {
+	e := newTestEngine(&EngConfig{})
+	for _, g := range []IRouter{e, e.NewGroup("/")} {
+		func() {
+			defer func() {
+				if err := recover(); err == nil || !strings.Contains(fmt.Sprint(err), "can not mount") {
+					t.Fatalf("mount at / should panic , err is %v", err)
+				}
+			}()
+			g.Mount("/", http.NotFoundHandler())
+		}()
+	}
+}
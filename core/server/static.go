@@ -0,0 +1,207 @@
+package server
+
+import (
+	"conan/log"
+	"conan/utils"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type StaticConfig struct {
+	Browse bool   // 是否允许列出目录下的文件 默认不允许
+	SPA    bool   // 单页应用模式 文件不存在时返回 Index
+	Index  string // 访问目录时返回的文件 默认为 index.html
+}
+
+var (
+	defaultStaticConf = &StaticConfig{
+		Index: "index.html",
+	}
+)
+
+// 复制一份配置再设置默认值 不修改调用方的配置 注册之后调用方再修改也不会影响
+func newStaticConf(conf *StaticConfig) *StaticConfig {
+	if conf == nil {
+		conf = defaultStaticConf
+	}
+	c := *conf
+	if c.Index == "" {
+		c.Index = defaultStaticConf.Index
+	}
+	return &c
+}
+
+// Static 将 root 目录下的文件挂载到 relativePath 下
+// eg: Static("/assets", "./public") 请求 /assets/js/a.js 返回 ./public/js/a.js
+func (r *RouterGroup) Static(relativePath string, root string) IRouter {
+	return r.StaticFS(relativePath, http.Dir(root), nil)
+}
+
+// StaticFS 与 Static 相同 可以自定义 http.FileSystem
+// relativePath 为 "/" 时 由于和其他路由冲突 这里会作为 NoRouter 注册 只有没有匹配到路由的 GET HEAD 请求才会查找文件
+func (r *RouterGroup) StaticFS(relativePath string, fs http.FileSystem, conf *StaticConfig) IRouter {
+	if strings.ContainsAny(relativePath, ":*") {
+		panic("URL parameters can not be used when serving a static folder")
+	}
+	s := &staticServer{fs: fs, conf: newStaticConf(conf)}
+
+	if r.calcAbsPath(relativePath) == "/" {
+		r.e.NoRouter(func(c *Context) {
+			if c.Req.Method != http.MethodGet && c.Req.Method != http.MethodHead {
+				default404Handler(c)
+				return
+			}
+			s.serve(c, c.Req.URL.Path)
+		})
+		return r
+	}
+
+	handler := func(c *Context) {
+		s.serve(c, c.Param("filepath"))
+	}
+	urlPattern := utils.JoinPath(relativePath, "/*filepath")
+	r.GET(urlPattern, handler)
+	r.HEAD(urlPattern, handler)
+	return r
+}
+
+// StaticFile 将单个文件挂载到 relativePath 下
+func (r *RouterGroup) StaticFile(relativePath string, file string) IRouter {
+	if strings.ContainsAny(relativePath, ":*") {
+		panic("URL parameters can not be used when serving a static file")
+	}
+
+	s := &staticServer{
+		fs:   http.Dir(filepath.Dir(file)),
+		conf: newStaticConf(nil),
+	}
+	name := "/" + filepath.Base(file)
+	handler := func(c *Context) {
+		s.serve(c, name)
+	}
+	r.GET(relativePath, handler)
+	r.HEAD(relativePath, handler)
+	return r
+}
+
+type staticServer struct {
+	fs   http.FileSystem
+	conf *StaticConfig
+}
+
+func (s *staticServer) serve(c *Context, name string) {
+	name = path.Clean("/" + name)
+
+	f, d, err := s.open(name)
+	if err != nil {
+		if s.conf.SPA && os.IsNotExist(err) {
+			s.serveIndex(c, "/")
+			return
+		}
+		s.serveError(c, err)
+		return
+	}
+	defer f.Close()
+
+	if d.IsDir() {
+		// 目录需要以 / 结尾 否则目录下文件的相对路径会出错
+		if urlPath := c.Req.URL.Path; !strings.HasSuffix(urlPath, "/") {
+			http.Redirect(c.Res, c.Req, path.Base(urlPath)+"/", http.StatusMovedPermanently)
+			c.Abort()
+			return
+		}
+		s.serveIndex(c, name)
+		return
+	}
+
+	s.serveContent(c, f, d)
+}
+
+func (s *staticServer) open(name string) (http.File, os.FileInfo, error) {
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, d, nil
+}
+
+// 访问目录时 优先返回目录下的 Index 文件 不存在时 根据配置决定是否列出目录
+func (s *staticServer) serveIndex(c *Context, dir string) {
+	index := path.Join(dir, s.conf.Index)
+	f, d, err := s.open(index)
+	if err == nil && !d.IsDir() {
+		defer f.Close()
+		s.serveContent(c, f, d)
+		return
+	}
+	if err == nil {
+		f.Close()
+	}
+
+	if !s.conf.Browse {
+		default404Handler(c)
+		return
+	}
+
+	f, _, err = s.open(dir)
+	if err != nil {
+		s.serveError(c, err)
+		return
+	}
+	defer f.Close()
+	s.dirList(c, f)
+}
+
+// http.ServeContent 会处理 Range If-Modified-Since If-None-Match 等请求头
+func (s *staticServer) serveContent(c *Context, f http.File, d os.FileInfo) {
+	c.Res.Header().Set("Etag", fmt.Sprintf(`W/"%x-%x"`, d.ModTime().UnixNano(), d.Size()))
+	http.ServeContent(c.Res, c.Req, d.Name(), d.ModTime(), f)
+	c.Abort()
+}
+
+func (s *staticServer) dirList(c *Context, f http.File) {
+	dirs, err := f.Readdir(-1)
+	if err != nil {
+		log.Error("Static Read Dir Fail err is %s", err.Error())
+		c.Byte(http.StatusInternalServerError, "text/plain; chatset=utf-8", default500Body)
+		return
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Name() < dirs[j].Name() })
+
+	builder := strings.Builder{}
+	builder.WriteString("<pre>\n")
+	for _, d := range dirs {
+		name := d.Name()
+		if d.IsDir() {
+			name += "/"
+		}
+		u := url.URL{Path: name}
+		builder.WriteString(fmt.Sprintf("<a href=\"%s\">%s</a>\n", u.String(), html.EscapeString(name)))
+	}
+	builder.WriteString("</pre>\n")
+	c.Byte(http.StatusOK, "text/html; charset=utf-8", []byte(builder.String()))
+}
+
+func (s *staticServer) serveError(c *Context, err error) {
+	switch {
+	case os.IsNotExist(err):
+		default404Handler(c)
+	case os.IsPermission(err):
+		c.Byte(http.StatusForbidden, "text/plain; chatset=utf-8", []byte("403 Forbidden"))
+	default:
+		log.Error("Static Open File Fail err is %s", err.Error())
+		c.Byte(http.StatusInternalServerError, "text/plain; chatset=utf-8", default500Body)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conan_static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	os.MkdirAll(filepath.Join(dir, "empty"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>index</html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "js", "app.js"), []byte("console.log('conan')"), 0644)
+	return dir
+}
+
+func TestStatic(t *testing.T) {
+	dir := staticDir(t)
+	defer os.RemoveAll(dir)
+
+	e := newTestEngine(&EngConfig{})
+	e.Static("/assets", dir)
+	e.StaticFS("/browse", http.Dir(dir), &StaticConfig{Browse: true})
+	spa := &StaticConfig{SPA: true}
+	e.StaticFS("/app", http.Dir(dir), spa)
+	e.StaticFile("/favicon.js", filepath.Join(dir, "js", "app.js"))
+	// 注册之后修改配置 不会影响已经注册的路由 也不会修改调用方的配置
+	spa.SPA = false
+	if spa.Index != "" || *defaultStaticConf != (StaticConfig{Index: "index.html"}) {
+		t.Fatalf("conf is %+v , default is %+v", spa, defaultStaticConf)
+	}
+
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/assets/js/app.js", 200, "console.log('conan')"},
+		{"/assets/", 200, "<html>index</html>"},
+		{"/assets/empty/", 404, string(default404Body)},
+		{"/assets/nothing.js", 404, string(default404Body)},
+		{"/assets/../server.go", 404, string(default404Body)},
+		{"/browse/empty/", 200, "<pre>\n</pre>\n"},
+		{"/app/users/1", 200, "<html>index</html>"},
+		{"/app/js/app.js", 200, "console.log('conan')"},
+		{"/favicon.js", 200, "console.log('conan')"},
+	}
+	for _, c := range cases {
+		w := doRequest(e, "GET", c.path)
+		if w.Code != c.code || w.Body.String() != c.body {
+			t.Fatalf("path %s code is %d , body is %s", c.path, w.Code, w.Body.String())
+		}
+	}
+
+	w := doRequest(e, "GET", "/assets/js")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/assets/js/" {
+		t.Fatalf("dir redirect code is %d , Location is %s", w.Code, w.Header().Get("Location"))
+	}
+
+	// Range
+	r := httptest.NewRequest("GET", "/assets/js/app.js", nil)
+	r.Header.Set("Range", "bytes=0-6")
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.String() != "console" {
+		t.Fatalf("range code is %d , body is %s", w.Code, w.Body.String())
+	}
+
+	// ETag
+	etag := w.Header().Get("Etag")
+	lastModified := w.Header().Get("Last-Modified")
+	r = httptest.NewRequest("GET", "/assets/js/app.js", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if etag == "" || w.Code != http.StatusNotModified {
+		t.Fatalf("etag is %s , code is %d", etag, w.Code)
+	}
+
+	// If-Modified-Since
+	r = httptest.NewRequest("GET", "/assets/js/app.js", nil)
+	r.Header.Set("If-Modified-Since", lastModified)
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since code is %d", w.Code)
+	}
+}
+
+func TestStaticRoot(t *testing.T) {
+	dir := staticDir(t)
+	defer os.RemoveAll(dir)
+
+	e := newTestEngine(&EngConfig{})
+	e.GET("/api/ping", func(c *Context) {
+		c.Byte(200, "text/plain", []byte("pong"))
+	})
+	e.StaticFS("/", http.Dir(dir), &StaticConfig{SPA: true})
+
+	for path, body := range map[string]string{
+		"/api/ping":  "pong",
+		"/js/app.js": "console.log('conan')",
+		"/users/1":   "<html>index</html>",
+	} {
+		w := doRequest(e, "GET", path)
+		if w.Code != 200 || !strings.Contains(w.Body.String(), body) {
+			t.Fatalf("path %s code is %d , body is %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	if w := doRequest(e, "POST", "/users/1"); w.Code != http.StatusNotFound {
+		t.Fatalf("POST code is %d", w.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"conan/log"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+)
+
+type TLSConfig struct {
+	CertFile     string   `yaml:"certFile"`
+	KeyFile      string   `yaml:"keyFile"`
+	MinVersion   string   `yaml:"minVersion"`   // 1.0 1.1 1.2 1.3 默认为 1.2
+	CipherSuites []string `yaml:"cipherSuites"` // tls.CipherSuites() 中的名字 为空时使用go默认的
+	ClientCAFile string   `yaml:"clientCAFile"` // 不为空时开启 mTLS 客户端必须提供该CA签发的证书
+	Reload       bool     `yaml:"reload"`       // 证书文件发生变化时 是否自动重新加载
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+func buildTLSConfig(conf *TLSConfig) (*tls.Config, *certReloader, error) {
+	reloader, err := newCertReloader(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if conf.MinVersion != "" {
+		v, ok := tlsVersions[conf.MinVersion]
+		if !ok {
+			return nil, nil, fmt.Errorf("tls min version %s is not supported", conf.MinVersion)
+		}
+		tlsConf.MinVersion = v
+	}
+
+	if len(conf.CipherSuites) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range tls.CipherSuites() {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range conf.CipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return nil, nil, fmt.Errorf("tls cipher suite %s is not supported", name)
+			}
+			tlsConf.CipherSuites = append(tlsConf.CipherSuites, id)
+		}
+	}
+
+	if conf.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, nil, fmt.Errorf("tls client ca file %s has no certificate", conf.ClientCAFile)
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if conf.Reload {
+		if err := reloader.watch(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tlsConf, reloader, nil
+}
+
+// certReloader 保存当前使用的证书 开启 watch 后 证书文件变化时会重新加载
+// 加载失败时 继续使用之前的证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Value // *tls.Certificate
+	watcher  *fsnotify.Watcher
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load().(*tls.Certificate), nil
+}
+
+// 这里监听的是文件所在的目录 证书一般是通过替换文件(k8s secret 是替换软链接)的方式更新的
+// 直接监听文件 文件被替换之后就收不到事件了
+func (r *certReloader) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := map[string]struct{}{
+		filepath.Dir(r.certFile): {},
+		filepath.Dir(r.keyFile):  {},
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := r.load(); err != nil {
+					// 证书和私钥可能不是同时写入的 这里等待下一次事件
+					log.Warn("TLS Reload Cert Fail file is %s , err is %s", event.Name, err.Error())
+					continue
+				}
+				log.Info("TLS Reload Cert Success file is %s", event.Name)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error("TLS Watch Cert Err is %s", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *certReloader) Close() {
+	if r.watcher != nil {
+		r.watcher.Close()
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"golang.org/x/net/http2"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// 生成一个证书 parent 为空时 生成自签名的CA
+func newTestCert(t *testing.T, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "conan"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, dir string) (string, string) {
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	// 先写入临时文件 再rename 保证证书和私钥同时生效
+	for file, data := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(file+".tmp", data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Rename(keyFile+".tmp", keyFile)
+	os.Rename(certFile+".tmp", certFile)
+	return certFile, keyFile
+}
+
+func TestTLSAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conan_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, 1, nil)
+	certFile, keyFile := newTestCert(t, 2, ca).write(t, dir)
+
+	addr := freeAddr(t)
+	e := NewEngine(&EngConfig{
+		Addr:            addr,
+		ShutdownTimeout: 3,
+		TLS: &TLSConfig{
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			MinVersion: "1.2",
+			Reload:     true,
+		},
+	})
+	e.GET("/ping", func(c *Context) {
+		c.Byte(200, "text/plain", []byte(c.Req.Proto))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(context.Background())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	newClient := func() *http.Client {
+		tr := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		http2.ConfigureTransport(tr)
+		return &http.Client{Transport: tr}
+	}
+
+	resp, err := newClient().Get("https://" + addr + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "HTTP/2.0" || resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Fatalf("proto is %s , serial is %s", string(b), resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	newTestCert(t, 3, ca).write(t, dir)
+	for i := 0; i < 50; i++ {
+		time.Sleep(50 * time.Millisecond)
+		resp, err = newClient().Get("https://" + addr + "/ping")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.TLS.PeerCertificates[0].SerialNumber.Int64() == 3 {
+			return
+		}
+	}
+	t.Fatal("cert is not reloaded")
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conan_mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, 1, nil)
+	certFile, keyFile := newTestCert(t, 2, ca).write(t, dir)
+	caFile := filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(caFile, ca.certPEM, 0600)
+
+	addr := freeAddr(t)
+	e := NewEngine(&EngConfig{
+		Addr:            addr,
+		ShutdownTimeout: 3,
+		TLS: &TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: caFile,
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		},
+	})
+	e.GET("/ping", func(c *Context) {
+		c.Byte(200, "text/plain", []byte("pong"))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(context.Background())
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	cli := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if _, err := cli.Get("https://" + addr + "/ping"); err == nil {
+		t.Fatal("client without cert should be rejected")
+	}
+
+	client := newTestCert(t, 4, ca)
+	clientCert, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	cli = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+	resp, err := cli.Get("https://" + addr + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("code is %d", resp.StatusCode)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	addr := freeAddr(t)
+	e := NewEngine(&EngConfig{Addr: addr, ShutdownTimeout: 3, H2C: true})
+	e.GET("/ping", func(c *Context) {
+		c.Byte(200, "text/plain", []byte(c.Req.Proto))
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Shutdown(context.Background())
+
+	cli := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+	resp, err := cli.Get("http://" + addr + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "HTTP/2.0" {
+		t.Fatalf("proto is %s", string(b))
+	}
+}
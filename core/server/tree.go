@@ -1,10 +1,52 @@
 package server
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type nodeType uint8
+
+const (
+	static   nodeType = iota // 普通的静态节点
+	root                     // method 下的根节点
+	param                    // :name 形式的参数节点
+	catchAll                 // *name 形式的通配节点 只能出现在path的最后
+)
+
+// Param 表示一个路由参数 Key 为注册时的参数名 Value 为请求中实际的值
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Params 按照注册路由中参数出现的顺序保存
+type Params []Param
+
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+func (ps Params) ByName(name string) string {
+	v, _ := ps.Get(name)
+	return v
+}
+
 type node struct {
-	children []*node
-	path     string
-	indices  string
-	handler  []HandlerFunc
+	children  []*node
+	path      string
+	indices   string
+	handler   []HandlerFunc
+	wildChild bool          // 儿子节点是否为 param 或者 catchAll 节点
+	nType     nodeType      // 节点类型
+	fullPath  string        // 注册时的完整路由
+	timeout   time.Duration // 该路由的超时时间 为0时使用 EngConfig.Timeout
 }
 
 type methodTree struct {
@@ -23,6 +65,13 @@ func (m methodTrees) getRoot(method string) *node {
 	return nil
 }
 
+// 查询时返回的结果
+type nodeValue struct {
+	handler  []HandlerFunc
+	fullPath string
+	timeout  time.Duration
+}
+
 func min(num1, num2 int) int {
 	if num1 > num2 {
 		return num2
@@ -30,20 +79,67 @@ func min(num1, num2 int) int {
 	return num1
 }
 
-func (n *node) addRouter(path string, handler ...HandlerFunc) {
+func longestCommonPrefix(a, b string) int {
+	i := 0
+	max := min(len(a), len(b))
+	for i < max && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// 返回path中参数的个数
+func countParams(path string) uint16 {
+	var n uint16
+	for i := 0; i < len(path); i++ {
+		if path[i] == ':' || path[i] == '*' {
+			n++
+		}
+	}
+	return n
+}
+
+// 查找path中的第一个通配段 返回该段、起始位置 以及该段是否合法
+// 一个段中只允许出现一个通配符
+func findWildcard(path string) (wildcard string, i int, valid bool) {
+	for start, c := range []byte(path) {
+		if c != ':' && c != '*' {
+			continue
+		}
+
+		valid = true
+		for end, c := range []byte(path[start+1:]) {
+			switch c {
+			case '/':
+				return path[start : start+1+end], start, valid
+			case ':', '*':
+				valid = false
+			}
+		}
+		return path[start:], start, valid
+	}
+	return "", -1, false
+}
+
+func (n *node) addRouter(path string, handler ...HandlerFunc) *node {
 	fullPath := path
-	rootPathLen := len(n.path)
-	if rootPathLen > 0 {
+
+	if len(n.path) == 0 && len(n.children) == 0 {
+		// 这里表示创建的是method的root节点
+		// 直接插入
+		n.nType = root
+		return n.insertNode(path, fullPath, handler...)
+	}
+
+	parentFullPathIndex := 0
+
+walk:
+	for {
 		// 查看 当前path 和 待插入path 是否有相同部分，
 		// 若有相同部分 则分割 待插入path，
-		minLen := min(rootPathLen, len(path))
-		var i int
-		for i < minLen && path[i] == n.path[i] {
-			i++
-		}
-
-		if i < rootPathLen {
+		i := longestCommonPrefix(path, n.path)
 
+		if i < len(n.path) {
 			/*
 					这里表示 当前插入的path 和root节点的path有不同的地方，
 					这时就该改变当前的root节点，将path[:i]作为新root节点的path
@@ -57,82 +153,257 @@ func (n *node) addRouter(path string, handler ...HandlerFunc) {
 			*/
 			// 这里就是在 将原来root的path 修改为 World,然后将该World变成 /Hello的一个儿子节点
 			child := &node{
-				children: n.children,
-				path:     n.path[i:],
-				indices:  n.indices,
-				handler:  n.handler,
+				children:  n.children,
+				path:      n.path[i:],
+				indices:   n.indices,
+				handler:   n.handler,
+				wildChild: n.wildChild,
+				nType:     static,
+				fullPath:  n.fullPath,
+				timeout:   n.timeout,
 			}
 			// 改变root节点的path
 			n.children = []*node{child}
 			n.indices = string([]byte{n.path[i]})
 			n.handler = nil
+			n.timeout = 0
+			n.wildChild = false
 			n.path = path[:i]
+			n.fullPath = fullPath[:parentFullPathIndex+i]
 		}
 
 		if i == len(path) {
+			if n.handler != nil {
+				panic(fmt.Sprintf("handlers are already registered for path %s", fullPath))
+			}
 			n.handler = handler
+			n.fullPath = fullPath
+			return n
 		}
 
-		if i < len(path) {
-			// 这里表示待插入的path和 root的不一样 需要插入
-			path = path[i:]
-			c := path[0]
+		// 这里表示待插入的path和 root的不一样 需要插入
+		path = path[i:]
 
-			// 若分割后的path 和当前 root的索引中有相同的。那么表示该path该成为已有节点的儿子
-			for j := 0; j < len(n.indices); j++ {
-				if c == n.indices[j] {
-					n = n.children[j]
-					n.addRouter(path, handler...)
-					return
-				}
+		if n.wildChild {
+			// 儿子是通配节点 那么待插入的path 必须和该通配节点一致
+			parentFullPathIndex += len(n.path)
+			n = n.children[0]
+
+			if len(path) >= len(n.path) && n.path == path[:len(n.path)] &&
+				n.nType != catchAll &&
+				(len(n.path) >= len(path) || path[len(n.path)] == '/') {
+				continue walk
 			}
 
-			// 若没有则表示 待插入节点该成为 root的儿子
+			pathSeg := path
+			if n.nType != catchAll {
+				pathSeg = strings.SplitN(path, "/", 2)[0]
+			}
+			prefix := fullPath[:strings.Index(fullPath, pathSeg)] + n.path
+			panic(fmt.Sprintf("'%s' in new path '%s' conflicts with existing wildcard '%s' in existing prefix '%s'",
+				pathSeg, fullPath, n.path, prefix))
+		}
+
+		c := path[0]
+
+		// 参数节点后面跟着的 '/'
+		if n.nType == param && c == '/' && len(n.children) == 1 {
+			parentFullPathIndex += len(n.path)
+			n = n.children[0]
+			continue walk
+		}
+
+		// 若分割后的path 和当前 root的索引中有相同的。那么表示该path该成为已有节点的儿子
+		for j := 0; j < len(n.indices); j++ {
+			if c == n.indices[j] {
+				parentFullPathIndex += len(n.path)
+				n = n.children[j]
+				continue walk
+			}
+		}
+
+		// 若没有则表示 待插入节点该成为 root的儿子
+		if c != ':' && c != '*' {
 			n.indices += string([]byte{c})
-			child := &node{}
+			child := &node{fullPath: fullPath}
 			n.children = append(n.children, child)
 			n = child
+		}
+		return n.insertNode(path, fullPath, handler...)
+	}
+}
 
-			n.insertNode(path, handler...)
-			return
+func (n *node) insertNode(path string, fullPath string, handler ...HandlerFunc) *node {
+	for {
+		wildcard, i, valid := findWildcard(path)
+		if i < 0 {
+			break
 		}
 
-	} else {
-		// 这里表示创建的是method的root节点
-		// 直接插入
-		n.insertNode(fullPath, handler...)
+		if !valid {
+			panic(fmt.Sprintf("only one wildcard per path segment is allowed, has '%s' in path '%s'", wildcard, fullPath))
+		}
+
+		if len(wildcard) < 2 {
+			panic(fmt.Sprintf("wildcards must be named with a non-empty name in path '%s'", fullPath))
+		}
+
+		// 通配节点不能和其他节点共存
+		if len(n.children) > 0 {
+			panic(fmt.Sprintf("wildcard segment '%s' conflicts with existing children in path '%s'", wildcard, fullPath))
+		}
+
+		if wildcard[0] == ':' {
+			if i > 0 {
+				n.path = path[:i]
+				path = path[i:]
+			}
+
+			n.wildChild = true
+			child := &node{
+				nType:    param,
+				path:     wildcard,
+				fullPath: fullPath,
+			}
+			n.children = []*node{child}
+			n = child
+
+			// 参数后面还有内容 继续插入
+			if len(wildcard) < len(path) {
+				path = path[len(wildcard):]
+				child := &node{fullPath: fullPath}
+				n.children = []*node{child}
+				n = child
+				continue
+			}
+
+			n.handler = handler
+			return n
+		}
+
+		// catchAll
+		if i+len(wildcard) != len(path) {
+			panic(fmt.Sprintf("catch-all routes are only allowed at the end of the path in path '%s'", fullPath))
+		}
+
+		if len(n.path) > 0 && n.path[len(n.path)-1] == '/' {
+			panic(fmt.Sprintf("catch-all conflicts with existing handle for the path segment root in path '%s'", fullPath))
+		}
+
+		i--
+		if i < 0 || path[i] != '/' {
+			panic(fmt.Sprintf("no / before catch-all in path '%s'", fullPath))
+		}
+
+		n.path = path[:i]
+
+		// 第一个节点 path为空 只用于标识 catchAll
+		child := &node{
+			wildChild: true,
+			nType:     catchAll,
+			fullPath:  fullPath,
+		}
+		n.children = []*node{child}
+		n.indices = string('/')
+		n = child
+
+		// 第二个节点 保存参数名以及handler
+		child = &node{
+			path:     path[i:],
+			nType:    catchAll,
+			handler:  handler,
+			fullPath: fullPath,
+		}
+		n.children = []*node{child}
+		return child
 	}
 
+	n.path = path
+	n.handler = handler
+	n.fullPath = fullPath
+	return n
 }
 
 func (n *node) getHandler(path string) []HandlerFunc {
+	return n.getValue(path, nil).handler
+}
 
-	if n.path == path {
-		return n.handler
-	}
+// 查找path对应的handler 若params不为空 则将匹配到的参数追加到params中
+func (n *node) getValue(path string, params *Params) (value nodeValue) {
+walk:
+	for {
+		prefix := n.path
+
+		if path == prefix {
+			value.handler = n.handler
+			value.fullPath = n.fullPath
+			value.timeout = n.timeout
+			return
+		}
 
-	if len(path) > len(n.path) {
 		/*
 			若path 是 当前node 的儿子 那么path 一定和node.path 有共同部分，
 			共同部分为 path[0~ len(n.path)]
 		*/
-		if path[:len(n.path)] == n.path {
+		if len(path) <= len(prefix) || path[:len(prefix)] != prefix {
+			return
+		}
 
-			path = path[len(n.path):]
-			c := path[0]
+		path = path[len(prefix):]
 
+		if !n.wildChild {
+			c := path[0]
 			for i := 0; i < len(n.indices); i++ {
 				if n.indices[i] == c {
 					n = n.children[i]
-					return n.getHandler(path)
+					continue walk
 				}
 			}
+			return
 		}
-	}
-	return nil
-}
 
-func (n *node) insertNode(path string, handler ...HandlerFunc) {
-	n.path = path
-	n.handler = handler
+		n = n.children[0]
+		switch n.nType {
+		case param:
+			end := 0
+			for end < len(path) && path[end] != '/' {
+				end++
+			}
+
+			if params != nil {
+				*params = append(*params, Param{
+					Key:   n.path[1:],
+					Value: path[:end],
+				})
+			}
+
+			if end < len(path) {
+				if len(n.children) > 0 {
+					path = path[end:]
+					n = n.children[0]
+					continue walk
+				}
+				return
+			}
+
+			value.handler = n.handler
+			value.fullPath = n.fullPath
+			value.timeout = n.timeout
+			return
+		case catchAll:
+			if params != nil {
+				*params = append(*params, Param{
+					Key:   n.path[2:],
+					Value: path,
+				})
+			}
+
+			value.handler = n.handler
+			value.fullPath = n.fullPath
+			value.timeout = n.timeout
+			return
+		default:
+			panic("invalid node type")
+		}
+	}
 }
@@ -3,6 +3,7 @@ package server
 import (
 	"testing"
 	"conan/log"
+	"time"
 )
 
 func Hello(c *Context) {
@@ -33,3 +34,110 @@ func TestTree(t *testing.T) {
 		return
 	}
 }
+
+// 分割节点时 超时时间需要跟着路由移动到新的节点
+func TestTreeTimeout(t *testing.T) {
+	root := &node{}
+	root.addRouter("/hello/world", Hello).timeout = time.Second
+	root.addRouter("/hello/me", Me)
+	root.addRouter("/hello", Hello).timeout = 2 * time.Second
+
+	cases := map[string]time.Duration{
+		"/hello/world": time.Second,
+		"/hello/me":    0,
+		"/hello":       2 * time.Second,
+	}
+	for path, timeout := range cases {
+		var ps Params
+		if value := root.getValue(path, &ps); value.handler == nil || value.timeout != timeout {
+			t.Fatalf("path is %s , timeout is %v , want %v", path, value.timeout, timeout)
+		}
+	}
+}
+
+func TestTreeParams(t *testing.T) {
+	root := &node{}
+
+	routes := []string{
+		"/",
+		"/users",
+		"/users/:id",
+		"/users/:id/books/:book",
+		"/static/*filepath",
+		"/search/",
+		"/search/:query",
+	}
+	for _, r := range routes {
+		root.addRouter(r, Hello)
+	}
+
+	cases := []struct {
+		path     string
+		fullPath string
+		params   Params
+	}{
+		{"/", "/", nil},
+		{"/users", "/users", nil},
+		{"/users/10", "/users/:id", Params{{"id", "10"}}},
+		{"/users/10/books/go", "/users/:id/books/:book", Params{{"id", "10"}, {"book", "go"}}},
+		{"/static/", "/static/*filepath", Params{{"filepath", "/"}}},
+		{"/static/css/a.css", "/static/*filepath", Params{{"filepath", "/css/a.css"}}},
+		{"/search/", "/search/", nil},
+		{"/search/conan", "/search/:query", Params{{"query", "conan"}}},
+	}
+
+	for _, c := range cases {
+		var ps Params
+		v := root.getValue(c.path, &ps)
+		if v.handler == nil {
+			t.Fatalf("path %s not found", c.path)
+		}
+		if v.fullPath != c.fullPath {
+			t.Fatalf("path %s fullPath is %s , want %s", c.path, v.fullPath, c.fullPath)
+		}
+		if len(ps) != len(c.params) {
+			t.Fatalf("path %s params is %v , want %v", c.path, ps, c.params)
+		}
+		for i := range ps {
+			if ps[i] != c.params[i] {
+				t.Fatalf("path %s params is %v , want %v", c.path, ps, c.params)
+			}
+		}
+	}
+
+	for _, p := range []string{"/user", "/users/10/books", "/static", "/nothing"} {
+		if h := root.getHandler(p); h != nil {
+			t.Fatalf("path %s should not be found", p)
+		}
+	}
+}
+
+func TestTreeConflict(t *testing.T) {
+	cases := []struct {
+		routes []string
+	}{
+		{[]string{"/users/:id", "/users/new"}},
+		{[]string{"/users/new", "/users/:id"}},
+		{[]string{"/users/:id", "/users/:name"}},
+		{[]string{"/static/*filepath", "/static/css"}},
+		{[]string{"/src/*filepath", "/src/*file"}},
+		{[]string{"/users", "/users"}},
+		{[]string{"/users/:id:name"}},
+		{[]string{"/users/:"}},
+		{[]string{"/static/*filepath/more"}},
+	}
+
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Fatalf("routes %v should conflict", c.routes)
+				}
+			}()
+			root := &node{}
+			for _, r := range c.routes {
+				root.addRouter(r, Hello)
+			}
+		}()
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"conan/log"
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 父进程的pid 子进程通过 getppid 校验 fd 是否是父进程传递的
+	envUpgradePPid = "CONAN_UPGRADE_PPID"
+	// 子进程启动完成后 通过该fd 通知父进程
+	envUpgradeReadyFd = "CONAN_UPGRADE_READY_FD"
+
+	defaultUpgradeTimeout = 60 * time.Second
+)
+
+var (
+	ErrUpgrading = errors.New("http server is upgrading")
+
+	upgradeReadyFile *os.File // 子进程中 用于通知父进程已经就绪
+)
+
+func inheritedFromParent() bool {
+	ppid, err := strconv.Atoi(os.Getenv(envUpgradePPid))
+	return err == nil && ppid == os.Getppid()
+}
+
+func loadUpgradeReadyFile() {
+	fd, err := strconv.Atoi(os.Getenv(envUpgradeReadyFd))
+	if err != nil || fd < listenFdsStart {
+		return
+	}
+	upgradeReadyFile = os.NewFile(uintptr(fd), "upgrade_ready")
+}
+
+// notifyUpgradeReady 平滑升级启动的子进程 RunServer 成功后通知父进程 父进程收到后开始关闭
+func notifyUpgradeReady() {
+	inheritedOnce.Do(loadInheritedFiles)
+
+	inheritedMu.Lock()
+	f := upgradeReadyFile
+	upgradeReadyFile = nil
+	inheritedMu.Unlock()
+
+	if f == nil {
+		return
+	}
+	defer f.Close()
+
+	// 父进程中通过 AddListener 增加的监听 子进程中可能没有配置 这里需要关闭 否则连接会一直等待
+	inheritedMu.Lock()
+	for name, l := range inheritedFiles {
+		log.Warn("Http Server Inherited Listener Is Not Used name is %s", name)
+		l.Close()
+		delete(inheritedFiles, name)
+	}
+	inheritedMu.Unlock()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Error("Http Server Notify Upgrade Ready Fail err is %s", err.Error())
+		return
+	}
+	log.Info("Http Server Upgrade Ready pid is %d , ppid is %d", os.Getpid(), os.Getppid())
+}
+
+// Upgrade 平滑升级 使用当前的可执行文件启动一个新的进程 并将所有的监听交给新进程
+// 新进程 RunServer 成功后 当前进程停止接收新连接 等待正在处理的请求完成后返回
+// 新进程启动失败或者超时时 当前进程继续提供服务 并返回错误
+func (e *Engine) Upgrade() error {
+	if e.isClosed() {
+		return errors.New("http server is not running")
+	}
+	if !atomic.CompareAndSwapInt32(&e.upgrading, 0, 1) {
+		return ErrUpgrading
+	}
+	defer atomic.StoreInt32(&e.upgrading, 0)
+
+	if err := e.startChild(); err != nil {
+		log.Error("Http Server Upgrade Fail err is %s", err.Error())
+		return err
+	}
+
+	// 这里先关闭监听 不改变服务的状态 已经建立的连接上的请求可以正常处理
+	// 直接 Shutdown 时 这些请求会收到 503
+	ctx := context.Background()
+	if e.cfg.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(e.cfg.ShutdownTimeout)*time.Second)
+		defer cancel()
+	}
+	err := e.stopListeners(ctx)
+	if serr := e.Shutdown(context.Background()); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// WaitSignal 阻塞直到服务关闭
+// 收到 upgrade 信号时 调用 Upgrade 平滑升级 成功后返回 失败时继续提供服务
+// 收到 stop 中的信号时 调用 Shutdown 关闭服务
+func (e *Engine) WaitSignal(upgrade os.Signal, stop ...os.Signal) error {
+	sigs := append([]os.Signal{}, stop...)
+	if upgrade != nil {
+		sigs = append(sigs, upgrade)
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+
+	for sig := range ch {
+		if upgrade != nil && sig == upgrade {
+			if err := e.Upgrade(); err != nil {
+				continue
+			}
+			return nil
+		}
+		log.Info("Http Server Receive Signal %s", sig.String())
+		return e.Shutdown(context.Background())
+	}
+	return nil
+}
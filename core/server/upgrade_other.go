@@ -0,0 +1,13 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"runtime"
+)
+
+// 平滑升级依赖 fork/exec 以及fd继承 只支持 unix
+func (e *Engine) startChild() error {
+	return errors.New("http server upgrade is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,158 @@
+//go:build unix
+
+package server
+
+import (
+	"conan/log"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (e *Engine) startChild() error {
+	e.lnMu.Lock()
+	names := make([]string, 0, len(e.listeners))
+	fds := make([]int, 0, len(e.listeners))
+	unixLns := make([]*net.UnixListener, 0)
+	for name, l := range e.listeners {
+		fd, err := dupListenerFd(l.ln)
+		if err != nil {
+			log.Warn("Http Server Upgrade Dup Listener Fail name is %s , err is %s", name, err.Error())
+			continue
+		}
+		// 当前进程关闭监听时 不能删除 socket 文件 子进程还在使用
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+			unixLns = append(unixLns, ul)
+		}
+		// LISTEN_FDNAMES 以 ':' 分割 这里需要转义
+		names = append(names, url.QueryEscape(name))
+		fds = append(fds, fd)
+	}
+	e.lnMu.Unlock()
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+
+	ok := false
+	defer func() {
+		if !ok {
+			for _, ul := range unixLns {
+				ul.SetUnlinkOnClose(true)
+			}
+		}
+	}()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	env := make([]string, 0, len(os.Environ())+4)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "LISTEN_") || strings.HasPrefix(kv, "CONAN_UPGRADE_") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(fds)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envUpgradePPid+"="+strconv.Itoa(os.Getpid()),
+		envUpgradeReadyFd+"="+strconv.Itoa(listenFdsStart+len(fds)),
+	)
+
+	// 这里不能使用 exec.Cmd 它会调用 os.File.Fd 将监听的fd设置为阻塞模式
+	// 而fd的阻塞模式是父子进程共享的 当前进程的 Accept 会一直阻塞 无法关闭
+	files := []uintptr{uintptr(syscall.Stdin), uintptr(syscall.Stdout), uintptr(syscall.Stderr)}
+	for _, fd := range fds {
+		files = append(files, uintptr(fd))
+	}
+	files = append(files, w.Fd())
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+	// 子进程已经继承了 w 这里需要关闭 否则子进程退出时读不到 EOF
+	w.Close()
+	if err != nil {
+		return err
+	}
+	child, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	log.Info("Http Server Upgrade Start Child pid is %d , listeners is %v", pid, names)
+
+	ready := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, err := r.Read(b); err != nil {
+			ready <- fmt.Errorf("child exit before ready err is %s", err.Error())
+			return
+		}
+		ready <- nil
+	}()
+
+	timeout := defaultUpgradeTimeout
+	if e.cfg.UpgradeTimeout > 0 {
+		timeout = time.Duration(e.cfg.UpgradeTimeout) * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-ready:
+	case <-timer.C:
+		err = errors.New("wait child ready timeout")
+	}
+	if err != nil {
+		child.Kill()
+		child.Wait()
+		return err
+	}
+
+	ok = true
+	log.Info("Http Server Upgrade Child Ready pid is %d", pid)
+	return nil
+}
+
+// 复制一个监听的fd 交给子进程
+func dupListenerFd(ln net.Listener) (int, error) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return -1, errors.New("listener is not support syscall conn")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	var fd int
+	var dupErr error
+	if err := rc.Control(func(s uintptr) {
+		// 和 net 包一样 在 ForkLock 中 dup 并设置 CLOEXEC
+		// 避免其他协程同时 fork/exec 时 无关的子进程继承了监听的fd
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(s)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	}); err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
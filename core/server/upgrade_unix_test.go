@@ -0,0 +1,122 @@
+//go:build unix
+
+package server
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 子进程中执行 收到 SIGHUP 时平滑升级 收到 SIGTERM 时退出
+func TestUpgradeHelper(t *testing.T) {
+	addr := os.Getenv("CONAN_TEST_UPGRADE_ADDR")
+	if addr == "" {
+		t.Skip("only run in child process")
+	}
+
+	e := NewEngine(&EngConfig{Addr: addr, ShutdownTimeout: 5, UpgradeTimeout: 10})
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	e.GET("/pid", func(c *Context) {
+		c.Byte(200, "text/plain", pid)
+	})
+	e.GET("/slow", func(c *Context) {
+		time.Sleep(500 * time.Millisecond)
+		c.Byte(200, "text/plain", pid)
+	})
+	if err := e.RunServer(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.WaitSignal(syscall.SIGHUP, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	addr := freeAddr(t)
+	base := "http://" + addr
+	cli := &http.Client{
+		Timeout:   3 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUpgradeHelper$")
+	cmd.Env = append(os.Environ(), "CONAN_TEST_UPGRADE_ADDR="+addr)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	oldPid := strconv.Itoa(cmd.Process.Pid)
+
+	ready := false
+	for i := 0; i < 100 && !ready; i++ {
+		time.Sleep(50 * time.Millisecond)
+		resp, err := cli.Get(base + "/pid")
+		if err == nil {
+			resp.Body.Close()
+			ready = true
+		}
+	}
+	if !ready {
+		t.Fatal("old process is not ready")
+	}
+	// 等待 WaitSignal 注册信号
+	time.Sleep(200 * time.Millisecond)
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := cli.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b := make([]byte, 16)
+		n, _ := resp.Body.Read(b)
+		slow <- string(b[:n])
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := cmd.Process.Signal(syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+
+	// 升级过程中 所有的请求都不能失败
+	newPid := oldPid
+	for i := 0; i < 200 && newPid == oldPid; i++ {
+		b := getBody(t, cli, base+"/pid")
+		newPid = b
+		time.Sleep(20 * time.Millisecond)
+	}
+	if newPid == oldPid {
+		t.Fatal("new process is not serving")
+	}
+	pid, _ := strconv.Atoi(newPid)
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	if b := <-slow; b != oldPid {
+		t.Fatalf("slow request response is %s , old pid is %s", b, oldPid)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("old process exit err is %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old process is not exited")
+	}
+
+	if b := getBody(t, cli, base+"/pid"); b != newPid {
+		t.Fatalf("pid is %s , new pid is %s", b, newPid)
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+}
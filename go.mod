@@ -13,6 +13,7 @@ require (
 	github.com/spf13/viper v1.7.1
 	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/net v0.0.0-20201006153459-a7d1128ccaa0
 	google.golang.org/grpc v1.33.2
 	gopkg.in/dgrijalva/jwt-go.v3 v3.2.0
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
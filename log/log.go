@@ -39,6 +39,15 @@ func Fatal(format string, args ...interface{}) {
 	defaultLog(FATAL, fmt.Sprintf(format, args...))
 }
 
+// InfoFields 以结构化的方式输出日志 每个field会作为单独的字段输出
+func InfoFields(msg string, fields ...zap.Field) {
+	defaultLog(INFO, msg, fields...)
+}
+
+func ErrorFields(msg string, fields ...zap.Field) {
+	defaultLog(ERROR, msg, fields...)
+}
+
 func CloseLog() error {
 
 	if logger == nil {
@@ -146,7 +146,7 @@ func (p *p2cPicker) pick(info balancer.PickInfo) (balancer.SubConn ,func(doneInf
 		oldStamp := atomic.SwapInt64(&pc.stamp , now)
 		// 根据 EWMA 预测 lag 和 success 公式为： Vt=βVt+(1−β)θt
 		// 计算  β = exp( （-(now-stmp)) / tau )
-		w := math.Exp( float64( (-(now-oldStamp)) / tau ))
+		w := math.Exp( float64(-(now-oldStamp)) / float64(tau) )
 		lag := now - start
 		if lag < 0 {
 			lag = 0
@@ -170,7 +170,7 @@ func (p *p2cPicker) pick(info balancer.PickInfo) (balancer.SubConn ,func(doneInf
 		atomic.StoreUint64(&pc.success , success)
 
 		if cpuStr , ok := doneInfo.Trailer[CPUUsage] ; ok {
-			if cpu , err := strconv.ParseUint(cpuStr[0] , 10 , 64); err != nil && cpu > 0 {
+			if cpu , err := strconv.ParseUint(cpuStr[0] , 10 , 64); err == nil && cpu > 0 {
 				atomic.StoreUint64(&pc.serCpu , cpu)
 			}
 		}
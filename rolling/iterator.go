@@ -6,8 +6,8 @@ type Iterator struct {
 	cur *Bucket
 }
 
-func  (i *Iterator)Next() bool {
-	return i.Count == i.iteratedCount
+func (i *Iterator) Next() bool {
+	return i.Count != i.iteratedCount
 }
 
 func (i *Iterator) Bucket() Bucket{
@@ -12,6 +12,7 @@ type RollingCounter interface {
 type RollingCounterOpts struct {
 	Size           int
 	BucketDuration time.Duration
+	Now            func() time.Time // 获取当前时间 为空时使用 time.Now
 }
 
 type rollingCounter struct {
@@ -20,7 +21,7 @@ type rollingCounter struct {
 
 func NewRollingCounter(opts RollingCounterOpts) RollingCounter {
 	window := NewWindow(WindowOpt{Size: opts.Size})
-	p := NewPolicy(window, PolicyOpts{BucketDuration: opts.BucketDuration})
+	p := NewPolicy(window, PolicyOpts{BucketDuration: opts.BucketDuration, Now: opts.Now})
 	return &rollingCounter{
 		policy: p,
 	}
@@ -1,7 +1,6 @@
 package rolling
 
 import (
-	"fmt"
 	"sync"
 	"time"
 )
@@ -14,35 +13,39 @@ type Policy struct {
 
 	bucketDuration time.Duration
 	lastAppendTime time.Time
+	now            func() time.Time
 }
 
 type PolicyOpts struct {
 	BucketDuration time.Duration
+	Now            func() time.Time // 获取当前时间 为空时使用 time.Now 测试时可以替换
 }
 
 func NewPolicy(window *Window, opts PolicyOpts) *Policy {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
 	return &Policy{
 		mu:             &sync.Mutex{},
 		size:           window.Size(),
 		window:         window,
 		bucketDuration: opts.BucketDuration,
-		lastAppendTime: time.Now(),
+		lastAppendTime: now(),
 		offset:         0,
+		now:            now,
 	}
 }
 
 // 计算从上次添加 到现在一共 经历了多少个 bucket
 func (p *Policy) timespan() int {
-	t := time.Since(p.lastAppendTime)
-	fmt.Println(t)
-	return int(t / p.bucketDuration)
+	return int(p.now().Sub(p.lastAppendTime) / p.bucketDuration)
 }
 
 // 主要用于计算 当前的offset。 并且清空 这段时间经过的bucket
 func (p *Policy) add(f func(offset int, val float64), val float64) {
 	p.mu.Lock()
 	timespan := p.timespan()
-	fmt.Println(timespan)
 	if timespan > 0 {
 		// 更新appendTime
 		p.lastAppendTime = p.lastAppendTime.Add(time.Duration(timespan * int(p.bucketDuration)))
@@ -0,0 +1,166 @@
+package cpu
+
+import (
+	"conan/sys/internal/cgroup"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// controller 读取当前进程所在 cgroup 的cpu信息 分为 v1 v2 两种实现
+type controller interface {
+	// 累计使用的cpu时间 单位ns
+	usage() (uint64, error)
+	// 可以使用的cpu核数 没有限制时返回0
+	quota() (float64, error)
+	// cpuset 中的cpu数量
+	cpus() (uint64, error)
+}
+
+// newCgroup 根据 root 下的 /sys/fs/cgroup 判断 cgroup 的版本 没有挂载 cgroup 时返回nil
+func newCgroup(root string) (controller, error) {
+	if !cgroup.Mounted(root) {
+		return nil, nil
+	}
+	paths, err := cgroup.Paths(root)
+	if err != nil {
+		return nil, err
+	}
+	if cgroup.IsV2(root) {
+		return &cgroupV2{dir: cgroup.Dir(filepath.Join(root, cgroup.Mount), paths[""])}, nil
+	}
+
+	// 不同的发行版 挂载的目录名不同
+	cg := &cgroupV1{
+		cpuacct: cgroup.V1Dir(root, paths, "cpuacct", "cpuacct", "cpu,cpuacct", "cpuacct,cpu"),
+		cpu:     cgroup.V1Dir(root, paths, "cpu", "cpu", "cpu,cpuacct", "cpuacct,cpu"),
+		cpuset:  cgroup.V1Dir(root, paths, "cpuset", "cpuset"),
+	}
+	if cg.cpuacct == "" {
+		return nil, fmt.Errorf("cpuacct is not mounted in %s", filepath.Join(root, cgroup.Mount))
+	}
+	return cg, nil
+}
+
+type cgroupV1 struct {
+	cpu     string
+	cpuacct string
+	cpuset  string
+}
+
+func (cg *cgroupV1) usage() (uint64, error) {
+	return cgroup.ReadUint(filepath.Join(cg.cpuacct, "cpuacct.usage"))
+}
+
+func (cg *cgroupV1) quota() (float64, error) {
+	if cg.cpu == "" {
+		return 0, nil
+	}
+	quota, err := cgroup.ReadInt(filepath.Join(cg.cpu, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, err
+	}
+	// -1 表示不限制
+	if quota <= 0 {
+		return 0, nil
+	}
+	period, err := cgroup.ReadInt(filepath.Join(cg.cpu, "cpu.cfs_period_us"))
+	if err != nil || period <= 0 {
+		return 0, err
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func (cg *cgroupV1) cpus() (uint64, error) {
+	if cg.cpuset == "" {
+		return 0, nil
+	}
+	return readCpuset(filepath.Join(cg.cpuset, "cpuset.cpus"))
+}
+
+type cgroupV2 struct {
+	dir string
+}
+
+func (cg *cgroupV2) usage() (uint64, error) {
+	file := filepath.Join(cg.dir, "cpu.stat")
+	values, err := cgroup.ReadKeyValues(file)
+	if err != nil {
+		return 0, err
+	}
+	usec, ok := values["usage_usec"]
+	if !ok {
+		return 0, fmt.Errorf("usage_usec is not found in %s", file)
+	}
+	return usec * 1000, nil
+}
+
+func (cg *cgroupV2) quota() (float64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(cg.dir, "cpu.max"))
+	if os.IsNotExist(err) {
+		// 根 cgroup 中没有 cpu.max
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 格式为 $MAX $PERIOD $MAX 为 max 时表示不限制
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, nil
+	}
+	quota, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	period, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil || period == 0 {
+		return 0, err
+	}
+	return float64(quota) / float64(period), nil
+}
+
+func (cg *cgroupV2) cpus() (uint64, error) {
+	n, err := readCpuset(filepath.Join(cg.dir, "cpuset.cpus.effective"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// 读取 cpuset 中的cpu数量 格式为 0-3,5,7-8
+func readCpuset(file string) (uint64, error) {
+	s, err := cgroup.ReadString(file)
+	if err != nil {
+		return 0, err
+	}
+	return parseCpuset(s)
+}
+
+func parseCpuset(s string) (uint64, error) {
+	var n uint64
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.ParseUint(bounds[0], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = strconv.ParseUint(bounds[1], 10, 64); err != nil {
+				return 0, err
+			}
+		}
+		if end < start {
+			return 0, fmt.Errorf("invalid cpuset %s", s)
+		}
+		n += end - start + 1
+	}
+	return n, nil
+}
@@ -1,17 +1,21 @@
 package cpu
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	interval = time.Millisecond * 500
+	interval    = time.Millisecond * 500
+	defaultRoot = "/"
 )
 
 var (
-	usage uint64
-	stats CPU
+	initOnce sync.Once
+	initErr  error // 第一次 Init 的结果 之后的调用都返回它
+	usage    uint64
+	stats    CPU
 )
 
 type CPU interface {
@@ -19,56 +23,163 @@ type CPU interface {
 	Info() Info
 }
 
+// cgroupCPU 在容器中时 计算当前 cgroup 的cpu使用率 不在 cgroup 中时计算整个机器的cpu使用率
 type cgroupCPU struct {
+	root      string
+	cgroup    controller // 为nil时 直接使用 /proc/stat
 	frequency uint64
-	quota     float64 // 表示 限制当前cgroup 可以使用的cpu时间 单位毫秒
-	cores     uint64  // cpu数量
+	quota     float64 // 表示 限制当前cgroup 可以使用的cpu核数 没有限制时为 cores
+	cores     uint64  // cpu数量 在 cgroup 中时为 cpuset 中的数量
+	hostCores uint64  // 机器的cpu数量 /proc/stat 中的时间是所有cpu的时间之和
 
-	preSystem uint64
-	preTotal  uint64 // 每次读取 cpu时  cpu总的运行时间
+	preSystem uint64 // 上次读取时 /proc/stat 中所有cpu的运行时间
+	preIdle   uint64
+	preTotal  uint64 // 上次读取时 cgroup 使用的cpu时间
 	usage     uint64
 }
 
-// 这里实际 需要去读取 cgroup 文件的值 然后 /sys/fs/cgroup 中去读取对应的值
+// NewCPU 读取 root 下的 /sys/fs/cgroup 以及 /proc 计算cpu使用率
+// root 一般为 / 测试时可以指向其他目录
+func NewCPU(root string) (CPU, error) {
+	stat, err := readProcStat(root)
+	if err != nil {
+		return nil, err
+	}
+	cg, err := newCgroup(root)
+	if err != nil {
+		return nil, err
+	}
+
+	cpu := &cgroupCPU{
+		root:      root,
+		cgroup:    cg,
+		frequency: readFrequency(root),
+		cores:     stat.cores,
+		hostCores: stat.cores,
+		preSystem: stat.total,
+		preIdle:   stat.idle,
+	}
+	if cg != nil {
+		if err := cpu.initCgroup(); err != nil {
+			return nil, err
+		}
+	}
+	if cpu.quota == 0 || cpu.quota > float64(cpu.cores) {
+		cpu.quota = float64(cpu.cores)
+	}
+	return cpu, nil
+}
+
+func (cpu *cgroupCPU) initCgroup() error {
+	cpus, err := cpu.cgroup.cpus()
+	if err != nil {
+		return err
+	}
+	if cpus > 0 && cpus < cpu.cores {
+		cpu.cores = cpus
+	}
+	if cpu.quota, err = cpu.cgroup.quota(); err != nil {
+		return err
+	}
+	cpu.preTotal, err = cpu.cgroup.usage()
+	return err
+}
+
+// Usage 返回两次调用之间的cpu使用率 范围为 0-1000 表示千分比
+// 在 cgroup 中时 相对于 quota 计算
 func (cpu *cgroupCPU) Usage() (uint64, error) {
-	return 0, nil
+	stat, err := readProcStat(cpu.root)
+	if err != nil {
+		return 0, err
+	}
+	// 两次读取的间隔太短 /proc/stat 还没有变化
+	if stat.total <= cpu.preSystem {
+		return cpu.usage, nil
+	}
+	system := stat.total - cpu.preSystem
+
+	if cpu.cgroup == nil {
+		idle := stat.idle - cpu.preIdle
+		if idle > system {
+			idle = system
+		}
+		cpu.usage = (system - idle) * 1000 / system
+	} else {
+		total, err := cpu.cgroup.usage()
+		if err != nil {
+			return 0, err
+		}
+		if total >= cpu.preTotal {
+			// system / hostCores 是经过的时间
+			cpu.usage = uint64(float64(total-cpu.preTotal) * float64(cpu.hostCores) * 1000 / (float64(system) * cpu.quota))
+			// 统计的误差可能会略微超过 quota
+			if cpu.usage > 1000 {
+				cpu.usage = 1000
+			}
+		}
+		cpu.preTotal = total
+	}
+	cpu.preSystem = stat.total
+	cpu.preIdle = stat.idle
+	return cpu.usage, nil
 }
 
 func (cpu *cgroupCPU) Info() Info {
 	return Info{
 		Frequency: cpu.frequency,
 		Quota:     cpu.quota,
+		Cores:     cpu.cores,
+		HostCores: cpu.hostCores,
 	}
 }
 
-func Init() {
-	stats = &cgroupCPU{}
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+// Init 开始定时采集cpu使用率 多次调用只会启动一次
+// 读取不到 /proc/stat 时(非linux) 返回错误 这时使用率一直为0
+func Init() error {
+	return initRoot(defaultRoot)
+}
 
-		for range ticker.C {
-			u, err := stats.Usage()
-			if err == nil && u != 0 {
-				atomic.StoreUint64(&usage, u)
-			}
+func initRoot(root string) error {
+	initOnce.Do(func() {
+		var c CPU
+		if c, initErr = NewCPU(root); initErr != nil {
+			return
 		}
-	}()
+		stats = c
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				u, err := stats.Usage()
+				if err == nil {
+					atomic.StoreUint64(&usage, u)
+				}
+			}
+		}()
+	})
+	return initErr
 }
 
 type State struct {
-	Usage uint64 // CPU 的使用率
+	Usage uint64 // CPU 的使用率 千分比
 }
 
 type Info struct {
-	Frequency uint64
-	Quota     float64
+	Frequency uint64  // 单位MHz
+	Quota     float64 // 可以使用的cpu核数
+	Cores     uint64  // 可以使用的cpu数量
+	HostCores uint64  // 机器的cpu数量
 }
 
 func ReadState(state *State) {
 	state.Usage = atomic.LoadUint64(&usage)
 }
 
+// GetInfo 返回cpu信息 需要先调用 Init
 func GetInfo() Info {
+	if stats == nil {
+		return Info{}
+	}
 	return stats.Info()
 }
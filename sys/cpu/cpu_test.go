@@ -0,0 +1,143 @@
+package cpu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempRoot(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conan_cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, root, name, content string) {
+	file := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 4个cpu 总时间为 total 空闲时间为 idle 单位为 1/100 s
+func procStatContent(total, idle int) string {
+	return fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\n", total-idle, idle) +
+		"cpu0 0 0 0 0 0 0 0 0 0 0\ncpu1 0 0 0 0 0 0 0 0 0 0\n" +
+		"cpu2 0 0 0 0 0 0 0 0 0 0\ncpu3 0 0 0 0 0 0 0 0 0 0\n" +
+		"intr 0\nctxt 0\n"
+}
+
+func TestCgroupV2(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/stat", procStatContent(1000, 500))
+	writeFile(t, root, "proc/self/cgroup", "0::/kubepods/pod1\n")
+	writeFile(t, root, "proc/cpuinfo", "processor\t: 0\ncpu MHz\t\t: 2400.000\nprocessor\t: 1\ncpu MHz\t\t: 2600.123\n")
+	writeFile(t, root, "sys/fs/cgroup/cgroup.controllers", "cpu cpuset memory\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/cpu.stat", "usage_usec 1000000\nuser_usec 800000\nsystem_usec 200000\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/cpu.max", "200000 100000\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/cpuset.cpus.effective", "0-3\n")
+
+	c, err := NewCPU(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := c.Info(); info != (Info{Frequency: 2600, Quota: 2, Cores: 4, HostCores: 4}) {
+		t.Fatalf("info is %+v", info)
+	}
+
+	// 4个cpu 经过1s 使用了1s的cpu时间 quota为2 使用率为 50%
+	writeFile(t, root, "proc/stat", procStatContent(1400, 700))
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/cpu.stat", "usage_usec 2000000\n")
+	if u, err := c.Usage(); err != nil || u != 500 {
+		t.Fatalf("usage is %d , err is %v", u, err)
+	}
+	// /proc/stat 没有变化时 返回上次的结果
+	if u, err := c.Usage(); err != nil || u != 500 {
+		t.Fatalf("usage is %d , err is %v", u, err)
+	}
+}
+
+func TestCgroupV1(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/stat", procStatContent(1000, 500))
+	// 容器中只挂载了自己的 cgroup 路径不存在时使用挂载的目录
+	writeFile(t, root, "proc/self/cgroup", "4:memory:/docker/abc\n3:cpuset:/docker/abc\n2:cpu,cpuacct:/docker/abc\n")
+	writeFile(t, root, "sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq", "3000000\n")
+	writeFile(t, root, "sys/fs/cgroup/cpu,cpuacct/cpuacct.usage", "1000000000\n")
+	writeFile(t, root, "sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us", "-1\n")
+	writeFile(t, root, "sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us", "100000\n")
+	writeFile(t, root, "sys/fs/cgroup/cpuset/docker/abc/cpuset.cpus", "0,2\n")
+
+	c, err := NewCPU(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不限制 quota 时 quota 为 cpuset 中的cpu数量
+	if info := c.Info(); info != (Info{Frequency: 3000, Quota: 2, Cores: 2, HostCores: 4}) {
+		t.Fatalf("info is %+v", info)
+	}
+
+	writeFile(t, root, "proc/stat", procStatContent(1400, 700))
+	writeFile(t, root, "sys/fs/cgroup/cpu,cpuacct/cpuacct.usage", "2500000000\n")
+	if u, err := c.Usage(); err != nil || u != 750 {
+		t.Fatalf("usage is %d , err is %v", u, err)
+	}
+}
+
+func TestProcStat(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/stat", procStatContent(1000, 500))
+
+	c, err := NewCPU(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := c.Info(); info != (Info{Quota: 4, Cores: 4, HostCores: 4}) {
+		t.Fatalf("info is %+v", info)
+	}
+
+	writeFile(t, root, "proc/stat", procStatContent(1400, 600))
+	if u, err := c.Usage(); err != nil || u != 750 {
+		t.Fatalf("usage is %d , err is %v", u, err)
+	}
+
+	if _, err := NewCPU(tempRoot(t)); err == nil {
+		t.Fatal("err should not be nil without /proc/stat")
+	}
+}
+
+func TestParseCpuset(t *testing.T) {
+	for s, n := range map[string]uint64{
+		"0":          1,
+		"0-3":        4,
+		"0-3,5,7-8":  7,
+		"":           0,
+		"1,3,5,7-15": 12,
+	} {
+		if got, err := parseCpuset(s); err != nil || got != n {
+			t.Fatalf("%q is %d , err is %v", s, got, err)
+		}
+	}
+	if _, err := parseCpuset("3-1"); err == nil {
+		t.Fatal("err should not be nil")
+	}
+}
+
+func TestInitErr(t *testing.T) {
+	dir := tempRoot(t)
+	// 失败之后 再次调用也需要返回错误
+	if err := initRoot(dir); err == nil {
+		t.Fatal("init should fail without /proc/stat")
+	}
+	if err := initRoot(dir); err == nil {
+		t.Fatal("second init should return the same error")
+	}
+}
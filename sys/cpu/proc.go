@@ -0,0 +1,96 @@
+package cpu
+
+import (
+	"bufio"
+	"conan/sys/internal/cgroup"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// /proc/stat 中时间的单位 linux 上固定为 1/100 s
+	userHZ    = 100
+	nsPerTick = 1e9 / userHZ
+)
+
+// procStat /proc/stat 中所有cpu的时间 单位ns
+type procStat struct {
+	total uint64
+	idle  uint64 // idle + iowait
+	cores uint64 // cpuN 的数量
+}
+
+func readProcStat(root string) (procStat, error) {
+	stat := procStat{}
+	f, err := os.Open(filepath.Join(root, "proc/stat"))
+	if err != nil {
+		return stat, err
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			stat.cores++
+			continue
+		}
+		// cpu user nice system idle iowait irq softirq steal guest guest_nice
+		// guest 已经包含在 user 中 这里只取前8列
+		for i := 1; i < len(fields) && i <= 8; i++ {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return stat, err
+			}
+			stat.total += v
+			if i == 4 || i == 5 {
+				stat.idle += v
+			}
+		}
+		found = true
+	}
+	if err := scanner.Err(); err != nil {
+		return stat, err
+	}
+	if !found {
+		return stat, errors.New("cpu is not found in /proc/stat")
+	}
+	stat.total *= nsPerTick
+	stat.idle *= nsPerTick
+	return stat, nil
+}
+
+// readFrequency 返回cpu的最大频率 单位MHz 读取不到时返回0
+// 优先使用 cpufreq 虚拟机中一般没有 这时使用 /proc/cpuinfo 中的当前频率
+func readFrequency(root string) uint64 {
+	khz, err := cgroup.ReadUint(filepath.Join(root, "sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq"))
+	if err == nil && khz > 0 {
+		return khz / 1000
+	}
+
+	f, err := os.Open(filepath.Join(root, "proc/cpuinfo"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var max float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "cpu MHz" {
+			continue
+		}
+		if mhz, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil && mhz > max {
+			max = mhz
+		}
+	}
+	return uint64(max)
+}
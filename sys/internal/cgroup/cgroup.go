@@ -0,0 +1,121 @@
+// Package cgroup 读取 cgroup 以及 /proc 中的文件 由 sys/cpu sys/mem 共用
+// 所有的路径都相对于 root 测试时可以指向其他目录
+package cgroup
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	Mount = "sys/fs/cgroup"
+)
+
+// Mounted root 下是否挂载了 cgroup
+func Mounted(root string) bool {
+	_, err := os.Stat(filepath.Join(root, Mount))
+	return err == nil
+}
+
+// IsV2 cgroup v2 只有一个层级 根目录下有 cgroup.controllers
+func IsV2(root string) bool {
+	_, err := os.Stat(filepath.Join(root, Mount, "cgroup.controllers"))
+	return err == nil
+}
+
+// Paths 读取 /proc/self/cgroup 返回 controller 对应的 cgroup 路径 v2 的 controller 为空字符串
+func Paths(root string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 格式为 id:controller1,controller2:path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// Dir 在 mount 下查找 cgroup 路径对应的目录
+// 容器中一般只挂载了自己的 cgroup 这时 /proc/self/cgroup 中的路径不存在 直接使用挂载的目录
+func Dir(mount, path string) string {
+	dir := filepath.Join(mount, path)
+	if _, err := os.Stat(dir); err == nil {
+		return dir
+	}
+	return mount
+}
+
+// V1Dir 返回 cgroup v1 中 controller 的目录 mounts 为可能的挂载目录名 没有挂载时返回空字符串
+func V1Dir(root string, paths map[string]string, controller string, mounts ...string) string {
+	for _, name := range mounts {
+		mount := filepath.Join(root, Mount, name)
+		if _, err := os.Stat(mount); err == nil {
+			return Dir(mount, paths[controller])
+		}
+	}
+	return ""
+}
+
+func ReadString(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func ReadUint(file string) (uint64, error) {
+	s, err := ReadString(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func ReadInt(file string) (int64, error) {
+	s, err := ReadString(file)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ReadKeyValues 读取 cpu.stat memory.stat /proc/meminfo 这种每行为 key value 的文件
+// key 后面的冒号会被去掉 value 只取第一个数字 单位需要调用方处理
+func ReadKeyValues(file string) (map[string]uint64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = v
+	}
+	return values, scanner.Err()
+}
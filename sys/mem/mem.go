@@ -0,0 +1,189 @@
+package mem
+
+import (
+	"conan/sys/internal/cgroup"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	interval    = time.Millisecond * 500
+	defaultRoot = "/"
+)
+
+var (
+	initOnce sync.Once
+	state    atomic.Value // *State 最近一次采集的结果
+)
+
+type Memory interface {
+	// Usage 返回正在使用的内存 以及内存的上限 单位byte
+	Usage() (current uint64, limit uint64, err error)
+	// RSS 返回当前进程的 RSS 单位byte
+	RSS() (uint64, error)
+}
+
+// cgroupMem 在容器中时 读取当前 cgroup 的内存 不在 cgroup 中时读取整个机器的内存
+type cgroupMem struct {
+	root string
+	dir  string // cgroup 中 memory 的目录 为空时使用 /proc/meminfo
+	v2   bool
+}
+
+// NewMemory 读取 root 下的 /sys/fs/cgroup 以及 /proc 获取内存信息
+// root 一般为 / 测试时可以指向其他目录
+func NewMemory(root string) (Memory, error) {
+	m := &cgroupMem{root: root}
+	if _, err := os.Stat(filepath.Join(root, "proc/meminfo")); err != nil {
+		return nil, err
+	}
+	if !cgroup.Mounted(root) {
+		return m, nil
+	}
+	paths, err := cgroup.Paths(root)
+	if err != nil {
+		return nil, err
+	}
+
+	current := "memory.usage_in_bytes"
+	if m.v2 = cgroup.IsV2(root); m.v2 {
+		m.dir = cgroup.Dir(filepath.Join(root, cgroup.Mount), paths[""])
+		current = "memory.current"
+	} else {
+		m.dir = cgroup.V1Dir(root, paths, "memory", "memory")
+	}
+	// 根 cgroup 中没有 memory.current 这时和不在 cgroup 中一样处理
+	if _, err := os.Stat(filepath.Join(m.dir, current)); m.dir != "" && err != nil {
+		m.dir = ""
+	}
+	return m, nil
+}
+
+// 机器的内存总量 以及可用的内存
+func (m *cgroupMem) meminfo() (total uint64, available uint64, err error) {
+	values, err := cgroup.ReadKeyValues(filepath.Join(m.root, "proc/meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return values["MemTotal"] * 1024, values["MemAvailable"] * 1024, nil
+}
+
+// Usage 在 cgroup 中时 current 不包含可以回收的 page cache(inactive_file) 和 kubelet 的 working set 一致
+// limit 没有限制或者超过机器的内存时 为机器的内存
+func (m *cgroupMem) Usage() (uint64, uint64, error) {
+	total, available, err := m.meminfo()
+	if err != nil {
+		return 0, 0, err
+	}
+	if m.dir == "" {
+		if available > total {
+			available = total
+		}
+		return total - available, total, nil
+	}
+
+	currentFile, limitFile, inactiveKey := "memory.usage_in_bytes", "memory.limit_in_bytes", "total_inactive_file"
+	if m.v2 {
+		currentFile, limitFile, inactiveKey = "memory.current", "memory.max", "inactive_file"
+	}
+	current, err := cgroup.ReadUint(filepath.Join(m.dir, currentFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	if stat, err := cgroup.ReadKeyValues(filepath.Join(m.dir, "memory.stat")); err == nil {
+		if inactive := stat[inactiveKey]; inactive < current {
+			current -= inactive
+		}
+	}
+
+	limit := total
+	// v2 中 max 表示不限制 v1 中不限制时是一个很大的值
+	if s, err := cgroup.ReadString(filepath.Join(m.dir, limitFile)); err == nil && s != "max" {
+		if l, err := strconv.ParseUint(s, 10, 64); err == nil && l > 0 && l < total {
+			limit = l
+		}
+	}
+	return current, limit, nil
+}
+
+func (m *cgroupMem) RSS() (uint64, error) {
+	values, err := cgroup.ReadKeyValues(filepath.Join(m.root, "proc/self/status"))
+	if err != nil {
+		return 0, err
+	}
+	// 单位为 kB
+	return values["VmRSS"] * 1024, nil
+}
+
+// Init 开始定时采集内存 goroutine gc 的信息 多次调用只会启动一次
+// 读取不到 /proc/meminfo 时(非linux) 返回错误 这时只采集 goroutine 以及 gc 的信息
+func Init() error {
+	var err error
+	initOnce.Do(func() {
+		var m Memory
+		m, err = NewMemory(defaultRoot)
+		gc := &debug.GCStats{PauseQuantiles: make([]time.Duration, 101)}
+		state.Store(sample(m, gc))
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				state.Store(sample(m, gc))
+			}
+		}()
+	})
+	return err
+}
+
+type State struct {
+	Usage      uint64 // 内存的使用率 千分比 Current / Limit
+	Current    uint64 // 正在使用的内存 单位byte
+	Limit      uint64 // 内存的上限 单位byte
+	RSS        uint64 // 当前进程的 RSS 单位byte
+	Goroutines int
+	NumGC      int64
+	// 最近256次gc 暂停时间的分位数
+	GCPauseP50 time.Duration
+	GCPauseP90 time.Duration
+	GCPauseP99 time.Duration
+	GCPauseMax time.Duration
+}
+
+// m 为nil时 只采集 goroutine 以及 gc 的信息
+// gc.PauseQuantiles 的长度为101 下标i为i%分位
+func sample(m Memory, gc *debug.GCStats) *State {
+	s := &State{Goroutines: runtime.NumGoroutine()}
+	if m != nil {
+		if current, limit, err := m.Usage(); err == nil && limit > 0 {
+			s.Current, s.Limit = current, limit
+			s.Usage = current * 1000 / limit
+		}
+		if rss, err := m.RSS(); err == nil {
+			s.RSS = rss
+		}
+	}
+
+	debug.ReadGCStats(gc)
+	s.NumGC = gc.NumGC
+	if gc.NumGC > 0 {
+		s.GCPauseP50 = gc.PauseQuantiles[50]
+		s.GCPauseP90 = gc.PauseQuantiles[90]
+		s.GCPauseP99 = gc.PauseQuantiles[99]
+		s.GCPauseMax = gc.PauseQuantiles[100]
+	}
+	return s
+}
+
+// ReadState 读取最近一次采集的结果 需要先调用 Init
+func ReadState(s *State) {
+	if last, ok := state.Load().(*State); ok {
+		*s = *last
+	}
+}
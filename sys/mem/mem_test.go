@@ -0,0 +1,120 @@
+package mem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"testing"
+	"time"
+)
+
+const meminfo = "MemTotal:        8000000 kB\nMemFree:         1000000 kB\nMemAvailable:    6000000 kB\n"
+
+func tempRoot(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conan_mem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func writeFile(t *testing.T, root, name, content string) {
+	file := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkUsage(t *testing.T, m Memory, current, limit uint64) {
+	c, l, err := m.Usage()
+	if err != nil || c != current || l != limit {
+		t.Fatalf("current is %d , limit is %d , err is %v", c, l, err)
+	}
+}
+
+func TestCgroupV2(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/meminfo", meminfo)
+	writeFile(t, root, "proc/self/status", "Name:\tconan\nVmRSS:\t   2048 kB\nThreads:\t8\n")
+	writeFile(t, root, "proc/self/cgroup", "0::/kubepods/pod1\n")
+	writeFile(t, root, "sys/fs/cgroup/cgroup.controllers", "cpu memory\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/memory.current", "300000000\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/memory.max", "1000000000\n")
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/memory.stat", "anon 200000000\nfile 100000000\ninactive_file 50000000\n")
+
+	m, err := NewMemory(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不包含 inactive_file
+	checkUsage(t, m, 250000000, 1000000000)
+	if rss, err := m.RSS(); err != nil || rss != 2048*1024 {
+		t.Fatalf("rss is %d , err is %v", rss, err)
+	}
+
+	// 不限制时 为机器的内存
+	writeFile(t, root, "sys/fs/cgroup/kubepods/pod1/memory.max", "max\n")
+	checkUsage(t, m, 250000000, 8000000*1024)
+}
+
+func TestCgroupV1(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/meminfo", meminfo)
+	writeFile(t, root, "proc/self/cgroup", "4:memory:/docker/abc\n2:cpu,cpuacct:/docker/abc\n")
+	writeFile(t, root, "sys/fs/cgroup/memory/memory.usage_in_bytes", "300000000\n")
+	writeFile(t, root, "sys/fs/cgroup/memory/memory.limit_in_bytes", "9223372036854771712\n")
+	writeFile(t, root, "sys/fs/cgroup/memory/memory.stat", "cache 100000000\ntotal_inactive_file 100000000\n")
+
+	m, err := NewMemory(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, m, 200000000, 8000000*1024)
+
+	writeFile(t, root, "sys/fs/cgroup/memory/memory.limit_in_bytes", "400000000\n")
+	checkUsage(t, m, 200000000, 400000000)
+}
+
+func TestMeminfo(t *testing.T) {
+	root := tempRoot(t)
+	writeFile(t, root, "proc/meminfo", meminfo)
+
+	m, err := NewMemory(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUsage(t, m, 2000000*1024, 8000000*1024)
+
+	if _, err := NewMemory(tempRoot(t)); err == nil {
+		t.Fatal("err should not be nil without /proc/meminfo")
+	}
+}
+
+type fakeMemory struct{}
+
+func (fakeMemory) Usage() (uint64, uint64, error) { return 300, 1000, nil }
+func (fakeMemory) RSS() (uint64, error)           { return 100, nil }
+
+func TestSample(t *testing.T) {
+	runtime.GC()
+	gc := &debug.GCStats{PauseQuantiles: make([]time.Duration, 101)}
+
+	s := sample(fakeMemory{}, gc)
+	if s.Usage != 300 || s.Current != 300 || s.Limit != 1000 || s.RSS != 100 {
+		t.Fatalf("state is %+v", s)
+	}
+	if s.Goroutines <= 0 || s.NumGC <= 0 || s.GCPauseMax < s.GCPauseP50 {
+		t.Fatalf("state is %+v", s)
+	}
+
+	// 非linux时 只有 goroutine 以及 gc 的信息
+	if s := sample(nil, gc); s.Limit != 0 || s.Goroutines <= 0 {
+		t.Fatalf("state is %+v", s)
+	}
+}